@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"speedtest-cron/speedtest"
+)
+
+// readCSVResults reads back the rows of a CSV file written by CSVWriter.
+// Bandwidth is converted back to Mbps using the unit named in the header. A
+// file written with -no-header is read by passing the header it would have
+// had; otherwise header is nil and read from the file. When limit is
+// positive only the last limit rows are returned, so memory stays bounded
+// however large the file is.
+func readCSVResults(filename string, comma rune, header []string, limit int) ([]*speedtest.FormattedSpeedTest, error) {
+	file, err := openCSVReader(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening CSV file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	if header == nil {
+		header, err = reader.Read()
+		if err == io.EOF {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV header: %w", err)
+		}
+	}
+
+	columns := make(map[string]int, len(header))
+	unit := "mbps"
+	for i, name := range header {
+		columns[name] = i
+		if suffix, ok := strings.CutPrefix(name, "download_"); ok {
+			for u, s := range bandwidthUnits {
+				if s == suffix {
+					unit = u
+				}
+			}
+			columns["download"] = i
+		}
+		if strings.HasPrefix(name, "upload_") {
+			columns["upload"] = i
+		}
+	}
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	number := func(record []string, name string) float64 {
+		// Accept files written with -decimal-separator=,
+		v, _ := strconv.ParseFloat(strings.Replace(field(record, name), ",", ".", 1), 64)
+		return v
+	}
+	// One unit of the file's bandwidth columns expressed in Mbps
+	toMbps := 1 / convertBandwidth(1, unit)
+
+	var results []*speedtest.FormattedSpeedTest
+	for {
+		record, err := reader.Read()
+		if err == io.EOF || isUnterminatedGzip(err) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV row: %w", err)
+		}
+		results = append(results, &speedtest.FormattedSpeedTest{
+			Timestamp:    field(record, "timestamp"),
+			PingMs:       number(record, "ping_ms"),
+			DownloadMbps: number(record, "download") * toMbps,
+			UploadMbps:   number(record, "upload") * toMbps,
+			JitterMs:     number(record, "jitter_ms"),
+			PacketLoss:   number(record, "packet_loss"),
+			ServerName:   field(record, "server_name"),
+			ServerID:     field(record, "server_id"),
+			ISP:          field(record, "isp"),
+			Backend:      field(record, "backend"),
+			Label:        field(record, "label"),
+			DurationMs:   int64(number(record, "duration_ms")),
+			RecordedAt:   field(record, "recorded_at"),
+
+			LatencyOnly: field(record, "download") == "" && field(record, "upload") == "",
+		})
+		if limit > 0 && len(results) > 2*limit {
+			// Drop the oldest rows in bulk rather than on every read
+			results = append(results[:0], results[len(results)-limit:]...)
+		}
+	}
+	if limit > 0 && len(results) > limit {
+		results = results[len(results)-limit:]
+	}
+	return results, nil
+}
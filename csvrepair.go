@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// repairCSVFile drops a trailing partial row left behind when the process was
+// killed mid-write, for -repair. The original file is copied to
+// filename.bak first. Files without problems, and missing files, are left
+// untouched. Damage anywhere but the last row is reported rather than
+// repaired, since dropping more than one row could lose data.
+func repairCSVFile(filename string, comma rune) error {
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading CSV file for repair: %w", err)
+	}
+
+	good := validCSVPrefix(data, comma)
+	if good == len(data) {
+		return nil
+	}
+	dropped := data[good:]
+	if bytes.Count(bytes.TrimRight(dropped, "\r\n"), []byte("\n")) > 0 {
+		return fmt.Errorf("CSV file %s is damaged before its last row; repair it by hand", filename)
+	}
+
+	if err := os.WriteFile(filename+".bak", data, 0644); err != nil {
+		return fmt.Errorf("error backing up CSV file before repair: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error repairing CSV file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data[:good]); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error repairing CSV file: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error repairing CSV file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error repairing CSV file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("error repairing CSV file: %w", err)
+	}
+	slog.Warn("Dropped a partial last row from the CSV file", "file", filename, "row", string(dropped), "backup", filename+".bak")
+	return nil
+}
+
+// validCSVPrefix returns the length of the longest prefix of data made of
+// complete, newline-terminated rows that all have as many fields as the
+// first one.
+func validCSVPrefix(data []byte, comma rune) int {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = comma
+	good := 0
+	for {
+		_, err := reader.Read()
+		if err == io.EOF {
+			return good
+		}
+		offset := int(reader.InputOffset())
+		if err != nil || data[offset-1] != '\n' {
+			return good
+		}
+		good = offset
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"speedtest-cron/speedtest"
+)
+
+// doctorCheck is one line of the -doctor checklist.
+type doctorCheck struct {
+	name   string
+	err    error
+	detail string
+	// hint tells the user how to fix a failed check.
+	hint string
+}
+
+// backendInstallHints says where to get each backend's CLI, keyed by the
+// name of the dependency checked.
+var backendInstallHints = map[string]string{
+	"ookla":      "install the Ookla speedtest CLI from https://www.speedtest.net/apps/cli",
+	"librespeed": "install librespeed-cli from https://github.com/librespeed/speedtest-cli",
+	"ping":       "install ping, e.g. the iputils-ping package",
+}
+
+// runDoctor checks that the selected backends and the output path are usable
+// and that a live test parses, prints a PASS/FAIL checklist and returns the
+// exit code. In latency mode the Ookla backend runs ping, which is checked
+// in its place.
+func runDoctor(cfg *Config, out io.Writer) int {
+	var checks []doctorCheck
+	// runnable is keyed by backend, whatever its tester is named
+	runnable := make(map[string]bool)
+	for _, backend := range cfg.backends() {
+		binary, flagName := cfg.backendBinary(backend)
+		dependency, args := backend, []string{"--version"}
+		if binary == "ping" {
+			// iputils ping has no reliable --version; ping the latency host once instead
+			dependency, args = "ping", []string{"-c", "1", cfg.LatencyHost}
+		}
+		path, err := speedtest.FindBinary(binary, flagName)
+		checks = append(checks, doctorCheck{
+			name:   dependency + " binary found",
+			err:    err,
+			detail: path,
+			hint:   backendInstallHints[dependency],
+		})
+		if err != nil {
+			continue
+		}
+		err = checkRunnable(path, args...)
+		checks = append(checks, doctorCheck{
+			name: dependency + " binary runnable",
+			err:  err,
+			hint: fmt.Sprintf("check that %s is executable by this user and built for this platform", path),
+		})
+		runnable[backend] = err == nil
+	}
+
+	outputs := []string{cfg.csvPath()}
+	if cfg.Format == "jsonl" {
+		outputs = []string{jsonlPath(cfg.Output)}
+	} else if cfg.Format == "both" {
+		outputs = append(outputs, jsonlPath(cfg.Output))
+	}
+	for _, output := range outputs {
+		checks = append(checks, doctorCheck{
+			name:   "output writable",
+			err:    checkWritable(output),
+			detail: output,
+			hint:   "choose a different -output or fix the permissions of its directory",
+		})
+	}
+
+	for _, backend := range cfg.backends() {
+		if !runnable[backend] {
+			continue
+		}
+		tester := cfg.speedTester(backend)
+		check := doctorCheck{
+			name: tester.Name() + " test parses",
+			hint: "run the CLI by hand to see its output; with ookla, -accept-license may be needed",
+		}
+		result, err := tester.Run(context.Background())
+		check.err = err
+		if err == nil && result.LatencyOnly {
+			check.detail = fmt.Sprintf("ping %.2f ms", result.PingMs)
+		} else if err == nil {
+			check.detail = fmt.Sprintf("download %.2f Mbps, upload %.2f Mbps, ping %.2f ms",
+				result.DownloadMbps, result.UploadMbps, result.PingMs)
+		}
+		checks = append(checks, check)
+	}
+
+	failed := 0
+	for _, check := range checks {
+		status := "PASS"
+		if check.err != nil {
+			status = "FAIL"
+			failed++
+		}
+		line := fmt.Sprintf("[%s] %s", status, check.name)
+		if check.detail != "" {
+			line += ": " + check.detail
+		}
+		fmt.Fprintln(out, line)
+		if check.err != nil {
+			fmt.Fprintf(out, "       %v\n       hint: %s\n", check.err, check.hint)
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(out, "%d of %d checks failed\n", failed, len(checks))
+		return 1
+	}
+	fmt.Fprintf(out, "All %d checks passed\n", len(checks))
+	return 0
+}
+
+// checkRunnable starts the binary with args, such as --version, to make sure
+// it executes.
+func checkRunnable(path string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if output, err := exec.CommandContext(ctx, path, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("error running %s %s: %w\nOutput: %s", path, strings.Join(args, " "), err, output)
+	}
+	return nil
+}
+
+// checkWritable reports whether filename can be appended to or, if it
+// doesn't exist yet, created, without leaving anything behind.
+func checkWritable(filename string) error {
+	if _, err := os.Stat(filename); err == nil {
+		file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		return file.Close()
+	}
+	// Find the nearest existing directory; missing ones are created on startup
+	dir := filepath.Dir(filename)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	file, err := os.CreateTemp(dir, ".speedtest-cron-doctor-*")
+	if err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(file.Name())
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakePing prints a ping summary like iputils ping, but rejects --version
+// as some builds do.
+const fakePing = `#!/bin/sh
+if [ "$1" = "--version" ]; then
+	echo "ping: invalid option -- '-'" >&2
+	exit 2
+fi
+echo "--- 1.1.1.1 ping statistics ---"
+echo "1 packets transmitted, 1 received, 0% packet loss, time 0ms"
+echo "rtt min/avg/max/mdev = 9.120/10.250/12.300/0.840 ms"
+`
+
+func TestDoctorLatencyMode(t *testing.T) {
+	bin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "ping"), []byte(fakePing), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin)
+
+	cfg := defaultConfig()
+	cfg.Mode = "latency"
+	cfg.Output = filepath.Join(t.TempDir(), "results.csv")
+	var out strings.Builder
+	if code := runDoctor(&cfg, &out); code != 0 {
+		t.Errorf("runDoctor() = %d, want 0; output:\n%s", code, &out)
+	}
+	for _, want := range []string{
+		"[PASS] ping binary found",
+		"[PASS] ping binary runnable",
+		"[PASS] ping test parses: ping 10.25 ms",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("runDoctor() output is missing %q:\n%s", want, &out)
+		}
+	}
+}
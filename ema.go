@@ -0,0 +1,43 @@
+package main
+
+import "speedtest-cron/speedtest"
+
+// ema is an exponential moving average; alpha, between 0 and 1, is the
+// weight of each new value.
+type ema struct {
+	alpha   float64
+	value   float64
+	started bool
+}
+
+// Add folds v into the average, which starts out as the first value, and
+// returns the new average.
+func (e *ema) Add(v float64) float64 {
+	if !e.started {
+		e.value, e.started = v, true
+	} else {
+		e.value = e.alpha*v + (1-e.alpha)*e.value
+	}
+	return e.value
+}
+
+// smoothedMetrics keeps an exponential moving average of download, upload
+// and ping for -ema-alpha.
+type smoothedMetrics struct {
+	download, upload, ping ema
+}
+
+func newSmoothedMetrics(alpha float64) *smoothedMetrics {
+	return &smoothedMetrics{download: ema{alpha: alpha}, upload: ema{alpha: alpha}, ping: ema{alpha: alpha}}
+}
+
+// Add folds result into the averages and returns them. Latency-only results
+// leave the bandwidth averages untouched.
+func (s *smoothedMetrics) Add(result *speedtest.FormattedSpeedTest) (downloadMbps, uploadMbps, pingMs float64) {
+	downloadMbps, uploadMbps = s.download.value, s.upload.value
+	if !result.LatencyOnly {
+		downloadMbps = s.download.Add(result.DownloadMbps)
+		uploadMbps = s.upload.Add(result.UploadMbps)
+	}
+	return downloadMbps, uploadMbps, s.ping.Add(result.PingMs)
+}
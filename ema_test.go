@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestSmoothedMetrics(t *testing.T) {
+	s := newSmoothedMetrics(0.5)
+	first := testResult()
+	s.Add(first)
+
+	second := testResult()
+	second.DownloadMbps, second.UploadMbps, second.PingMs = 50, 10, 20.3
+	download, upload, ping := s.Add(second)
+	if download != 75 || upload != 15 || ping != 16.3 {
+		t.Errorf("Add() = %v, %v, %v, want 75, 15, 16.3", download, upload, ping)
+	}
+
+	latencyOnly := testResult()
+	latencyOnly.LatencyOnly = true
+	latencyOnly.DownloadMbps, latencyOnly.PingMs = 0, 16.3
+	download, upload, ping = s.Add(latencyOnly)
+	if download != 75 || upload != 15 || ping != 16.3 {
+		t.Errorf("Add() of a latency-only result = %v, %v, %v, want the bandwidth averages unchanged", download, upload, ping)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"speedtest-cron/speedtest"
+)
+
+// errorLogHeader is the header row of a CSV error log.
+var errorLogHeader = []string{"timestamp", "backend", "attempts", "category", "message"}
+
+// errorLogEntry is one test that failed for good, after all retries.
+type errorLogEntry struct {
+	Timestamp string `json:"timestamp"`
+	Backend   string `json:"backend"`
+	Attempts  int    `json:"attempts"`
+	Category  string `json:"category"`
+	Message   string `json:"message"`
+}
+
+func newErrorLogEntry(timestamp, backend string, err error) *errorLogEntry {
+	entry := &errorLogEntry{
+		Timestamp: timestamp,
+		Backend:   backend,
+		Attempts:  1,
+		Category:  speedtest.ErrorCategory(err),
+		Message:   strings.TrimSpace(err.Error()),
+	}
+	var re *speedtest.RetryError
+	if errors.As(err, &re) {
+		entry.Attempts = re.Attempts
+	}
+	return entry
+}
+
+// ErrorLog appends every final test failure to -error-log, as JSON Lines if
+// the filename ends in .jsonl and as CSV otherwise.
+type ErrorLog struct {
+	file *os.File
+	csv  *csv.Writer
+}
+
+func newErrorLog(filename string) (*ErrorLog, error) {
+	if err := ensureDir(filename); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening error log: %w", err)
+	}
+	l := &ErrorLog{file: file}
+	if strings.HasSuffix(filename, ".jsonl") {
+		return l, nil
+	}
+	l.csv = csv.NewWriter(file)
+	if info, err := file.Stat(); err == nil && info.Size() == 0 {
+		if err := l.writeCSV(errorLogHeader); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *ErrorLog) Write(entry *errorLogEntry) error {
+	if l.csv == nil {
+		if err := json.NewEncoder(l.file).Encode(entry); err != nil {
+			return fmt.Errorf("error writing to error log: %w", err)
+		}
+		return nil
+	}
+	return l.writeCSV([]string{entry.Timestamp, entry.Backend, strconv.Itoa(entry.Attempts), entry.Category, entry.Message})
+}
+
+func (l *ErrorLog) writeCSV(record []string) error {
+	if err := l.csv.Write(record); err != nil {
+		return fmt.Errorf("error writing to error log: %w", err)
+	}
+	l.csv.Flush()
+	if err := l.csv.Error(); err != nil {
+		return fmt.Errorf("error writing to error log: %w", err)
+	}
+	return nil
+}
+
+func (l *ErrorLog) Close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Compressed CSV output keeps one gzip stream open per file and flushes it
+// after every row, so each row reaches the disk straight away and can be read
+// back even though the stream has no trailer until the file is closed. A
+// stream can't be appended to after a restart, so an existing file is read
+// back and rewritten as a fresh stream on open, which also recovers the rows
+// of a file left unterminated by a crash.
+
+// openCSVReader opens a CSV file for reading, decompressing it if its name
+// ends in .gz.
+func openCSVReader(filename string) (io.ReadCloser, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	if !isGzipPath(filename) {
+		return file, nil
+	}
+	gz, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading gzip header: %w", err)
+	}
+	return &gzipReadCloser{Reader: gz, file: file}, nil
+}
+
+type gzipReadCloser struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r *gzipReadCloser) Close() error {
+	r.Reader.Close()
+	return r.file.Close()
+}
+
+// isUnterminatedGzip reports whether err marks the end of a gzip stream that
+// is still being written or was cut off by a crash; everything before it was
+// read successfully.
+func isUnterminatedGzip(err error) bool {
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func isGzipPath(filename string) bool {
+	return strings.HasSuffix(filename, ".gz")
+}
+
+// splitExt splits filename into its stem and extension, treating a
+// compressed extension such as .csv.gz as one.
+func splitExt(filename string) (string, string) {
+	ext := filepath.Ext(filename)
+	stem := strings.TrimSuffix(filename, ext)
+	if ext == ".gz" {
+		inner := filepath.Ext(stem)
+		stem = strings.TrimSuffix(stem, inner)
+		ext = inner + ext
+	}
+	return stem, ext
+}
+
+// openGzipCSV starts a gzip stream for filename with the header written,
+// carrying over the rows of an existing file. A file with a different header
+// is refused, or moved aside when force is set.
+func openGzipCSV(filename string, header []string, opts csvOptions) (*os.File, *gzip.Writer, error) {
+	var rows [][]string
+	if info, err := os.Stat(filename); err == nil && info.Size() > 0 {
+		rows, err = readGzipCSV(filename, opts.comma)
+		if err != nil {
+			return nil, nil, err
+		}
+		if header != nil && len(rows) > 0 && strings.Join(rows[0], ",") != strings.Join(header, ",") {
+			if !opts.force {
+				return nil, nil, fmt.Errorf("%w: %s has header %q but this version writes %q; move the old file aside, pass -force to do so automatically, or choose a different -output",
+					errCSVHeaderMismatch, filename, strings.Join(rows[0], ","), strings.Join(header, ","))
+			}
+			rotated := nextRotatedFilename(filename, time.Now())
+			if err := os.Rename(filename, rotated); err != nil {
+				return nil, nil, fmt.Errorf("error moving old CSV file aside: %w", err)
+			}
+			slog.Warn("Moved CSV file with a different header aside", "file", filename, "moved_to", rotated)
+			rows = nil
+		}
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, nil, fmt.Errorf("error checking CSV file: %w", err)
+	}
+	if len(rows) == 0 && header != nil {
+		rows = [][]string{header}
+	}
+
+	if err := ensureDir(filename); err != nil {
+		return nil, nil, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating CSV file: %w", err)
+	}
+	gz := gzip.NewWriter(tmp)
+	writer := opts.newWriter(gz)
+	writer.WriteAll(rows)
+	err = tmp.Chmod(0644)
+	if err == nil {
+		err = writer.Error()
+	}
+	if err == nil {
+		err = gz.Flush()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, nil, fmt.Errorf("error writing compressed CSV file: %w", err)
+	}
+	return tmp, gz, nil
+}
+
+// readGzipCSV reads every row of a compressed CSV file, keeping what could be
+// read from an unterminated stream.
+func readGzipCSV(filename string, comma rune) ([][]string, error) {
+	file, err := openCSVReader(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening CSV file: %w", err)
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	var rows [][]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF || isUnterminatedGzip(err) {
+			if err != io.EOF {
+				slog.Warn("Recovered rows from an unterminated compressed CSV file", "file", filename, "rows", len(rows))
+			}
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV file: %w", err)
+		}
+		rows = append(rows, record)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"speedtest-cron/speedtest"
+)
+
+const (
+	defaultHistorySince = 24 * time.Hour
+	defaultHistoryStep  = time.Hour
+	// maxHistoryBuckets caps the size of a /history response.
+	maxHistoryBuckets = 1000
+)
+
+// historyBucket is the average of the results measured in one step-long
+// interval starting at Start. Latency-only results count towards the ping
+// average but not the bandwidth ones.
+type historyBucket struct {
+	Start        string  `json:"start"`
+	Count        int     `json:"count"`
+	DownloadMbps float64 `json:"download_mbps"`
+	UploadMbps   float64 `json:"upload_mbps"`
+	PingMs       float64 `json:"ping_ms"`
+}
+
+// handleHistory returns the results recorded in the last ?since= duration,
+// read from every CSV file the window covers and averaged over ?step= long
+// buckets. Buckets without results are left out.
+func (s *StatusServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	since, ok := durationParam(w, r, "since", defaultHistorySince)
+	if !ok {
+		return
+	}
+	step, ok := durationParam(w, r, "step", defaultHistoryStep)
+	if !ok {
+		return
+	}
+	if step > since {
+		http.Error(w, "step must not be longer than since", http.StatusBadRequest)
+		return
+	}
+	if since/step >= maxHistoryBuckets {
+		http.Error(w, "too many buckets: increase step or shorten since", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	start := now.Add(-since).Truncate(step)
+	results, err := s.readResults(start, now)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("Error reading history", "error", err)
+		http.Error(w, "error reading results", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(downsample(results, start, step))
+}
+
+// durationParam parses the named query parameter as a positive duration,
+// answering 400 and returning false if it isn't one.
+func durationParam(w http.ResponseWriter, r *http.Request, name string, fallback time.Duration) (time.Duration, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return fallback, true
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		http.Error(w, name+" must be a positive duration such as 24h or 15m", http.StatusBadRequest)
+		return 0, false
+	}
+	return d, true
+}
+
+// downsample averages the results recorded at or after start into buckets
+// of length step, in time order.
+func downsample(results []*speedtest.FormattedSpeedTest, start time.Time, step time.Duration) []historyBucket {
+	type sums struct {
+		count, bandwidthCount  int
+		download, upload, ping float64
+	}
+	buckets := make(map[int64]*sums)
+	var keys []int64
+	for _, result := range results {
+		t, err := recordedTime(result)
+		if err != nil || t.Before(start) {
+			continue
+		}
+		key := int64(t.Sub(start) / step)
+		b, ok := buckets[key]
+		if !ok {
+			b = &sums{}
+			buckets[key] = b
+			keys = append(keys, key)
+		}
+		b.count++
+		b.ping += result.PingMs
+		if !result.LatencyOnly {
+			b.bandwidthCount++
+			b.download += result.DownloadMbps
+			b.upload += result.UploadMbps
+		}
+	}
+	slices.Sort(keys)
+
+	history := make([]historyBucket, 0, len(keys))
+	for _, key := range keys {
+		b := buckets[key]
+		bucket := historyBucket{
+			Start:  start.Add(time.Duration(key) * step).UTC().Format(time.RFC3339),
+			Count:  b.count,
+			PingMs: b.ping / float64(b.count),
+		}
+		if b.bandwidthCount > 0 {
+			bucket.DownloadMbps = b.download / float64(b.bandwidthCount)
+			bucket.UploadMbps = b.upload / float64(b.bandwidthCount)
+		}
+		history = append(history, bucket)
+	}
+	return history
+}
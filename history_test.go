@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestCSV writes a CSV file with a header and one row per result
+// recorded at each of the given times, from a CLI whose clock is a day off.
+func writeTestCSV(t *testing.T, filename string, recorded ...time.Time) {
+	t.Helper()
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	opts := testCSVOptions()
+	writer := csv.NewWriter(file)
+	writer.Write(csvHeader(opts.unit, nil))
+	for _, at := range recorded {
+		result := testResult()
+		result.Timestamp = at.AddDate(0, 0, -1).Format(time.RFC3339)
+		result.RecordedAt = at.Format(time.RFC3339)
+		writer.Write(toCSV(result, opts))
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistoryReadsEveryDailyFile(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Output = filepath.Join(t.TempDir(), "results.csv")
+	cfg.Rotate = "daily"
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1)
+	writeTestCSV(t, dailyFilename(cfg.Output, yesterday), yesterday.Add(10*time.Minute))
+	writeTestCSV(t, dailyFilename(cfg.Output, now), now.Add(-time.Minute), now.Add(-2*time.Minute))
+	// Outside the window
+	writeTestCSV(t, dailyFilename(cfg.Output, now.AddDate(0, 0, -3)), now.AddDate(0, 0, -3))
+
+	s := newStatusServer(&cfg)
+	rec := httptest.NewRecorder()
+	s.handleHistory(rec, httptest.NewRequest(http.MethodGet, "/history?since=48h&step=24h", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /history = %d: %s", rec.Code, rec.Body)
+	}
+	var buckets []historyBucket
+	if err := json.NewDecoder(rec.Body).Decode(&buckets); err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, bucket := range buckets {
+		count += bucket.Count
+	}
+	if count != 3 {
+		t.Errorf("GET /history counted %d results in %v, want the 3 recorded in the last 48h", count, buckets)
+	}
+}
+
+func TestHistoryWithoutOutput(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Output = filepath.Join(t.TempDir(), "results.csv")
+	s := newStatusServer(&cfg)
+	rec := httptest.NewRecorder()
+	s.handleHistory(rec, httptest.NewRequest(http.MethodGet, "/history", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "[]\n" {
+		t.Errorf("GET /history without a CSV file = %d %q, want an empty list", rec.Code, rec.Body)
+	}
+}
+
+func TestHistoryReadsRotatedFiles(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Output = filepath.Join(t.TempDir(), "results.csv")
+	now := time.Now()
+	writeTestCSV(t, rotatedFilename(cfg.Output, now.Add(-time.Hour), 0), now.Add(-3*time.Hour), now.Add(-2*time.Hour))
+	writeTestCSV(t, cfg.Output, now.Add(-time.Minute))
+
+	s := newStatusServer(&cfg)
+	rec := httptest.NewRecorder()
+	s.handleHistory(rec, httptest.NewRequest(http.MethodGet, "/history?since=24h&step=1h", nil))
+	var buckets []historyBucket
+	if err := json.NewDecoder(rec.Body).Decode(&buckets); err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, bucket := range buckets {
+		count += bucket.Count
+	}
+	if count != 3 {
+		t.Errorf("GET /history counted %d results in %v, want 3 including the rotated file", count, buckets)
+	}
+}
+
+func TestRotatedCSVsSince(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "results.csv")
+	now := time.Now().Truncate(time.Second)
+	names := []string{
+		rotatedFilename(base, now.Add(-48*time.Hour), 0),
+		rotatedFilename(base, now.Add(-time.Hour), 1),
+		rotatedFilename(base, now.Add(-time.Hour), 0),
+		rotatedFilename(dailyFilename(base, now), now, 0),
+		dailyFilename(base, now),
+		filepath.Join(dir, "other-20240601T100000.csv"),
+	}
+	for _, name := range names {
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := rotatedCSVsSince(base, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{names[2], names[1], names[3]}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("rotatedCSVsSince() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"speedtest-cron/speedtest"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+const (
+	defaultDataLimit = 500
+	maxDataLimit     = 5000
+)
+
+// StatusServer serves the most recent result, a health check and a small
+// dashboard over HTTP. It implements ResultSink so it sees every result,
+// and closing it shuts the HTTP server down.
+type StatusServer struct {
+	server *http.Server
+	// healthWindow is how recent the last success must be for /healthz to
+	// report healthy.
+	healthWindow time.Duration
+	// csvFile returns the CSV file the dashboard data is read from.
+	csvFile  func() string
+	csvComma rune
+	// csvHeader is set when the file has no header row of its own.
+	csvHeader []string
+	// readResults returns the results of every CSV file that may hold
+	// results recorded between start and end, see readStatsResults.
+	readResults func(start, end time.Time) ([]*speedtest.FormattedSpeedTest, error)
+	// tlsCert and tlsKey serve HTTPS instead of HTTP when set.
+	tlsCert, tlsKey string
+	// runTest runs and records a test for POST /run, returning false if one
+	// is already running; nil until the monitor is set up.
+	runTest func() ([]*speedtest.FormattedSpeedTest, bool, error)
+
+	mu          sync.RWMutex
+	latest      *speedtest.FormattedSpeedTest
+	lastSuccess time.Time
+}
+
+func newStatusServer(cfg *Config) *StatusServer {
+	s := &StatusServer{
+		healthWindow: healthWindow(cfg),
+		csvFile: func() string {
+			return csvFilename(cfg.csvPath(), cfg.Rotate == "daily", time.Now())
+		},
+		csvComma: cfg.csvComma(),
+		readResults: func(start, end time.Time) ([]*speedtest.FormattedSpeedTest, error) {
+			return readStatsResults(cfg, start, end)
+		},
+		tlsCert: cfg.HTTPTLSCert,
+		tlsKey:  cfg.HTTPTLSKey,
+	}
+	if cfg.NoHeader {
+		s.csvHeader = csvHeader(cfg.Units, cfg.Tags.keys())
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleDashboard)
+	mux.HandleFunc("/data", s.handleData)
+	mux.HandleFunc("/history", s.handleHistory)
+	mux.HandleFunc("/latest", s.handleLatest)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/run", s.handleRun)
+	var handler http.Handler = mux
+	if cfg.HTTPAuth != "" {
+		user, password, _ := strings.Cut(cfg.HTTPAuth, ":")
+		handler = requireBasicAuth(handler, user, password)
+	}
+	s.server = &http.Server{Addr: cfg.HTTPAddr, Handler: handler}
+	return s
+}
+
+// requireBasicAuth answers 401 to every request that doesn't carry the
+// given basic auth credentials, and passes the others on to next.
+func requireBasicAuth(next http.Handler, user, password string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		// Compare both in constant time so timing reveals neither
+		userOK := subtle.ConstantTimeCompare([]byte(u), []byte(user)) == 1
+		passwordOK := subtle.ConstantTimeCompare([]byte(p), []byte(password)) == 1
+		if !ok || !userOK || !passwordOK {
+			w.Header().Set("WWW-Authenticate", `Basic realm="speedtest-cron", charset="UTF-8"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Start serves requests in the background until Close is called.
+func (s *StatusServer) Start() {
+	go func() {
+		var err error
+		if s.tlsCert != "" {
+			slog.Info("Serving HTTPS status", "addr", s.server.Addr)
+			err = s.server.ListenAndServeTLS(s.tlsCert, s.tlsKey)
+		} else {
+			slog.Info("Serving HTTP status", "addr", s.server.Addr)
+			err = s.server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server error", "error", err)
+		}
+	}()
+}
+
+func (s *StatusServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
+
+// handleData returns the last ?limit= results from the CSV file as JSON.
+func (s *StatusServer) handleData(w http.ResponseWriter, r *http.Request) {
+	limit := defaultDataLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxDataLimit)
+	}
+
+	results, err := readCSVResults(s.csvFile(), s.csvComma, s.csvHeader, limit)
+	if errors.Is(err, fs.ErrNotExist) {
+		results = nil
+	} else if err != nil {
+		slog.Error("Error reading dashboard data", "error", err)
+		http.Error(w, "error reading results", http.StatusInternalServerError)
+		return
+	}
+	if results == nil {
+		results = []*speedtest.FormattedSpeedTest{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+func (s *StatusServer) handleLatest(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	latest := s.latest
+	s.mu.RUnlock()
+
+	if latest == nil {
+		http.Error(w, "no successful speed test yet", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(latest)
+}
+
+// runResponse is the JSON body of POST /run.
+type runResponse struct {
+	Results []*speedtest.FormattedSpeedTest `json:"results"`
+	Errors  []runError                      `json:"errors,omitempty"`
+}
+
+// runError is the error of one backend that failed in POST /run.
+type runError struct {
+	Backend string `json:"backend,omitempty"`
+	Error   string `json:"error"`
+}
+
+// handleRun runs a test right away on POST, records it like a scheduled one
+// and returns the recorded results and the errors of the backends that
+// failed as a runResponse. The status is 200 if every backend recorded a
+// result, 207 if only some did and 502 if none did.
+func (s *StatusServer) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST to run a test", http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.RLock()
+	runTest := s.runTest
+	s.mu.RUnlock()
+	if runTest == nil {
+		http.Error(w, "not ready to run tests yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	slog.Info("Running a test on request", "source", "http", "remote_addr", r.RemoteAddr)
+	results, ran, err := runTest()
+	if !ran {
+		http.Error(w, "a speed test is already running", http.StatusConflict)
+		return
+	}
+	response := runResponse{Results: results}
+	if response.Results == nil {
+		response.Results = []*speedtest.FormattedSpeedTest{}
+	}
+	for _, backendErr := range backendErrors(err) {
+		response.Errors = append(response.Errors, runError{Backend: backendErr.backend, Error: backendErr.err.Error()})
+	}
+	status := http.StatusOK
+	switch {
+	case err != nil && len(results) == 0:
+		status = http.StatusBadGateway
+	case err != nil:
+		status = http.StatusMultiStatus
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// setRunTest enables POST /run.
+func (s *StatusServer) setRunTest(runTest func() ([]*speedtest.FormattedSpeedTest, bool, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runTest = runTest
+}
+
+// healthWindow returns the longest time the schedule goes without a test,
+// including the jitter: the -interval, or with -cron the longest gap between
+// two runs.
+func healthWindow(cfg *Config) time.Duration {
+	window := cfg.Interval
+	if cfg.Cron != "" {
+		gap, err := longestCronGap(cfg.Cron, time.Now())
+		if err != nil {
+			slog.Warn("Could not work out the cron schedule, /healthz allows -interval between tests", "error", err)
+		} else {
+			window = gap
+		}
+	}
+	return window + cfg.Jitter
+}
+
+func (s *StatusServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	lastSuccess := s.lastSuccess
+	s.mu.RUnlock()
+
+	if lastSuccess.IsZero() || time.Since(lastSuccess) > s.healthWindow {
+		http.Error(w, "no successful speed test within "+s.healthWindow.String(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+func (s *StatusServer) Write(result *speedtest.FormattedSpeedTest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latest = result
+	s.lastSuccess = time.Now()
+	return nil
+}
+
+func (s *StatusServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
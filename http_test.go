@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"speedtest-cron/speedtest"
+)
+
+func TestHandleRun(t *testing.T) {
+	ok := &fakeTester{name: "ookla", run: func(ctx context.Context) (*speedtest.FormattedSpeedTest, error) {
+		return testResult(), nil
+	}}
+	failing := &fakeTester{name: "librespeed", run: func(ctx context.Context) (*speedtest.FormattedSpeedTest, error) {
+		return nil, errors.New("error running librespeed-cli: exit status 1")
+	}}
+	tests := []struct {
+		name       string
+		testers    []speedtest.Tester
+		wantStatus int
+		wantResult int
+		wantErrors []string
+	}{
+		{"all recorded", []speedtest.Tester{ok}, http.StatusOK, 1, nil},
+		{"some recorded", []speedtest.Tester{ok, failing}, http.StatusMultiStatus, 1, []string{"librespeed"}},
+		{"none recorded", []speedtest.Tester{failing}, http.StatusBadGateway, 0, []string{"librespeed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestMonitor(tt.testers...)
+			m.cfg.Output = filepath.Join(t.TempDir(), "results.csv")
+			s := newStatusServer(m.cfg)
+			s.setRunTest(func() ([]*speedtest.FormattedSpeedTest, bool, error) {
+				return m.tryRunTest(context.Background())
+			})
+
+			rec := httptest.NewRecorder()
+			s.handleRun(rec, httptest.NewRequest(http.MethodPost, "/run", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("POST /run = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			var response runResponse
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Results) != tt.wantResult {
+				t.Errorf("POST /run returned %d results, want %d", len(response.Results), tt.wantResult)
+			}
+			var backends []string
+			for _, runErr := range response.Errors {
+				if !strings.Contains(runErr.Error, "exit status 1") {
+					t.Errorf("error of %s = %q", runErr.Backend, runErr.Error)
+				}
+				backends = append(backends, runErr.Backend)
+			}
+			if strings.Join(backends, ",") != strings.Join(tt.wantErrors, ",") {
+				t.Errorf("POST /run reported errors for %v, want %v", backends, tt.wantErrors)
+			}
+		})
+	}
+}
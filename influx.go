@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"speedtest-cron/speedtest"
+)
+
+// InfluxWriter writes each result as a point to an InfluxDB v2 bucket using
+// the line protocol.
+type InfluxWriter struct {
+	writeURL string
+	token    string
+	client   *http.Client
+}
+
+func newInfluxWriter(cfg *Config) *InfluxWriter {
+	query := url.Values{}
+	query.Set("org", cfg.InfluxOrg)
+	query.Set("bucket", cfg.InfluxBucket)
+	query.Set("precision", "s")
+	return &InfluxWriter{
+		writeURL: strings.TrimSuffix(cfg.InfluxURL, "/") + "/api/v2/write?" + query.Encode(),
+		token:    cfg.InfluxToken,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// influxLine formats result as a line protocol point in the speedtest
+// measurement, tagged by server, ISP and backend.
+func influxLine(result *speedtest.FormattedSpeedTest) string {
+	var line strings.Builder
+	line.WriteString("speedtest")
+	for _, tag := range []struct{ key, value string }{
+		{"server", result.ServerName},
+		{"server_id", result.ServerID},
+		{"isp", result.ISP},
+		{"backend", result.Backend},
+	} {
+		// Line protocol doesn't allow empty tag values
+		if tag.value != "" {
+			line.WriteString("," + tag.key + "=" + escapeInfluxTag(tag.value))
+		}
+	}
+	line.WriteString(" ")
+	if !result.LatencyOnly {
+		fmt.Fprintf(&line, "download=%s,upload=%s,",
+			strconv.FormatFloat(result.DownloadMbps, 'f', -1, 64),
+			strconv.FormatFloat(result.UploadMbps, 'f', -1, 64))
+	}
+	fmt.Fprintf(&line, "ping=%s", strconv.FormatFloat(result.PingMs, 'f', -1, 64))
+
+	timestamp, err := time.Parse(time.RFC3339, result.Timestamp)
+	if err != nil {
+		timestamp = time.Now()
+	}
+	fmt.Fprintf(&line, " %d\n", timestamp.Unix())
+	return line.String()
+}
+
+var influxTagEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+func escapeInfluxTag(value string) string {
+	return influxTagEscaper.Replace(value)
+}
+
+func (w *InfluxWriter) Write(result *speedtest.FormattedSpeedTest) error {
+	req, err := http.NewRequest(http.MethodPost, w.writeURL, strings.NewReader(influxLine(result)))
+	if err != nil {
+		return fmt.Errorf("error creating InfluxDB request: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error writing to InfluxDB: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("InfluxDB responded with %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+func (w *InfluxWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestInfluxLine(t *testing.T) {
+	result := testResult()
+	result.ServerName = "Town Hall"
+	got := influxLine(result)
+	want := "speedtest,server=Town\\ Hall,server_id=1234,isp=Acme,backend=ookla download=100,upload=20,ping=12.3 1717236000\n"
+	if got != want {
+		t.Errorf("influxLine() = %q, want %q", got, want)
+	}
+
+	result.LatencyOnly = true
+	result.Backend = "ping"
+	want = "speedtest,server=Town\\ Hall,server_id=1234,isp=Acme,backend=ping ping=12.3 1717236000\n"
+	if got := influxLine(result); got != want {
+		t.Errorf("influxLine() of a latency-only result = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockPath returns the lock file guarding output, named after it so it
+// survives the output being rotated or compressed.
+func lockPath(output string) string {
+	return output + ".lock"
+}
+
+// lockOutput takes an advisory lock that stops a second instance from
+// writing to the same output and corrupting it. The lock is held until the
+// returned file is closed, or the process exits.
+func lockOutput(output string) (*os.File, error) {
+	path := lockPath(output)
+	if err := ensureDir(path); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening lock file: %w", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("another instance is already writing to %s (it holds %s); stop it, choose a different -output or pass -no-lock", output, path)
+		}
+		return nil, fmt.Errorf("error locking %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// outputLock is the lock held on the output of the running configuration.
+// A nil *outputLock holds nothing, as with stdout or -no-lock.
+type outputLock struct {
+	path string
+	file *os.File
+}
+
+// lockTarget returns the output cfg must lock, or "" if none.
+func lockTarget(cfg *Config) string {
+	if cfg.Output == stdoutPath || cfg.NoLock {
+		return ""
+	}
+	return cfg.csvPath()
+}
+
+// lockFor takes the lock cfg's output needs, or returns nil if it needs none.
+func lockFor(cfg *Config) (*outputLock, error) {
+	path := lockTarget(cfg)
+	if path == "" {
+		return nil, nil
+	}
+	file, err := lockOutput(path)
+	if err != nil {
+		return nil, err
+	}
+	return &outputLock{path: path, file: file}, nil
+}
+
+// covers reports whether l is the lock cfg's output needs.
+func (l *outputLock) covers(cfg *Config) bool {
+	if l == nil {
+		return lockTarget(cfg) == ""
+	}
+	return lockTarget(cfg) == l.path
+}
+
+// Close releases the lock.
+func (l *outputLock) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// rotatingFile is an io.WriteCloser that appends to a log file and, when
+// maxSize is set, rotates it to name.1, name.2, ... keeping at most
+// maxBackups old files.
+type rotatingFile struct {
+	mu         sync.Mutex
+	name       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(name string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	if err := ensureDir(name); err != nil {
+		return nil, err
+	}
+	f := &rotatingFile{name: name, maxSize: maxSize, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error reading log file size: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			// Keep logging to the current file; losing log lines is worse
+			fmt.Fprintf(os.Stderr, "error rotating log file: %v\n", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if f.maxBackups > 0 {
+		// Shift name.N-1 to name.N, dropping the oldest, then name to name.1
+		os.Remove(f.backupName(f.maxBackups))
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			os.Rename(f.backupName(i), f.backupName(i+1))
+		}
+		if err := os.Rename(f.name, f.backupName(1)); err != nil {
+			f.open()
+			return err
+		}
+	} else if err := os.Remove(f.name); err != nil {
+		f.open()
+		return err
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) backupName(i int) string {
+	return f.name + "." + strconv.Itoa(i)
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.file.Sync(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"speedtest-cron/speedtest"
+)
+
+// setupLogging installs the default slog logger according to the configured
+// level and format. The standard log package is routed through it as well.
+// When logging to a file, the returned closer must be closed on shutdown.
+func setupLogging(cfg *Config) (io.Closer, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: must be debug, info, warn or error", cfg.LogLevel)
+	}
+	if cfg.Quiet {
+		level = max(level, slog.LevelWarn)
+	}
+
+	var out io.Writer = os.Stderr
+	var closer io.Closer
+	if cfg.LogFile != "" {
+		file, err := openRotatingFile(cfg.LogFile, int64(cfg.LogMaxSize), cfg.LogMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out, closer = file, file
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch strings.ToLower(cfg.LogFormat) {
+	case "text":
+		handler = slog.NewTextHandler(out, opts)
+	case "json":
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, fmt.Errorf("invalid log format %q: must be text or json", cfg.LogFormat)
+	}
+	slog.SetDefault(slog.New(handler))
+	return closer, nil
+}
+
+// resultAttrs returns the fields of result as structured log attributes.
+func resultAttrs(result *speedtest.FormattedSpeedTest) []any {
+	return []any{
+		"timestamp", result.Timestamp,
+		"download_mbps", result.DownloadMbps,
+		"upload_mbps", result.UploadMbps,
+		"ping_ms", result.PingMs,
+		"jitter_ms", result.JitterMs,
+		"packet_loss", result.PacketLoss,
+		"server_name", result.ServerName,
+		"server_id", result.ServerID,
+		"isp", result.ISP,
+		"backend", result.Backend,
+		"label", result.Label,
+		"duration_ms", result.DurationMs,
+	}
+}
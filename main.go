@@ -1,194 +1,252 @@
 package main
 
 import (
-	"encoding/csv"
-	"encoding/json"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"os/exec"
 	"os/signal"
-	"strconv"
-	"strings"
 	"syscall"
 	"time"
+
+	"speedtest-cron/speedtest"
 )
 
-type SpeedTestResult struct {
-	Type      string    `json:"type"`
-	Timestamp time.Time `json:"timestamp"`
-	Ping      struct {
-		Latency float64 `json:"latency"`
-	} `json:"ping"`
-	Download struct {
-		Bandwidth int64 `json:"bandwidth"`
-	} `json:"download"`
-	Upload struct {
-		Bandwidth int64 `json:"bandwidth"`
-	} `json:"upload"`
-}
+// shutdownTimeout bounds how long flushing outputs may take after a signal.
+const shutdownTimeout = 15 * time.Second
 
-type FormattedSpeedTest struct {
-	Timestamp    string  `json:"timestamp"`
-	PingMs       float64 `json:"ping_ms"`
-	DownloadMbps float64 `json:"download_mbps"`
-	UploadMbps   float64 `json:"upload_mbps"`
+func main() {
+	os.Exit(run())
 }
 
-func (f *FormattedSpeedTest) toCSV() []string {
-	return []string{
-		f.Timestamp,
-		strconv.FormatFloat(f.PingMs, 'f', 2, 64),
-		strconv.FormatFloat(f.DownloadMbps, 'f', 2, 64),
-		strconv.FormatFloat(f.UploadMbps, 'f', 2, 64),
+// run starts the monitor and returns the process exit code once it stops.
+func run() int {
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
 	}
-}
-
-func ensureCSVFile(filename string) (*os.File, error) {
-	if _, err := os.Stat(filename); os.IsNotExist(err) {
-		file, err := os.Create(filename)
-		if err != nil {
-			return nil, fmt.Errorf("error creating CSV file: %w", err)
-		}
-		writer := csv.NewWriter(file)
-		header := []string{"timestamp", "ping_ms", "download_mbps", "upload_mbps"}
-		if err := writer.Write(header); err != nil {
-			file.Close()
-			return nil, fmt.Errorf("error writing CSV header: %w", err)
-		}
-		writer.Flush()
-		if err := writer.Error(); err != nil {
-			file.Close()
-			return nil, fmt.Errorf("error flushing CSV writer: %w", err)
-		}
-		return file, nil
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		return 1
+	}
+	if cfg.ShowVersion {
+		fmt.Printf("speedtest-cron %s (commit %s, built %s)\n", version, commit, date)
+		return 0
+	}
+	if cfg.Doctor {
+		return runDoctor(cfg, os.Stdout)
+	}
+	if cfg.Stats {
+		return runStats(cfg, os.Stdout)
 	}
-	return os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
-}
-
-func parseSpeedTestOutput(output []byte) (*FormattedSpeedTest, error) {
-	lines := strings.Split(string(output), "\n")
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
 
-		// Try to parse each line as a JSON object
-		var result SpeedTestResult
-		if err := json.Unmarshal([]byte(line), &result); err != nil {
-			continue
-		}
+	// Set up logging
+	logFile, err := setupLogging(cfg)
+	if err != nil {
+		slog.Error("Failed to set up logging", "error", err)
+		return 1
+	}
+	if logFile != nil {
+		defer logFile.Close()
+	}
+	slog.Info("Starting speedtest monitoring service...")
 
-		// Only process "result" type entries
-		if result.Type != "result" {
-			continue
+	for _, backend := range cfg.backends() {
+		if _, err := speedtest.FindBinary(cfg.backendBinary(backend)); err != nil {
+			if cfg.Lenient {
+				slog.Warn("Speed test binary not found, continuing because of -lenient; tests fail until it appears", "backend", backend, "error", err)
+				continue
+			}
+			slog.Error("Failed to find speedtest", "backend", backend, "error", err)
+			return 1
 		}
-
-		// Convert bandwidth from bytes/s to Mbps
-		downloadMbps := float64(result.Download.Bandwidth) * 8 / 1_000_000
-		uploadMbps := float64(result.Upload.Bandwidth) * 8 / 1_000_000
-
-		return &FormattedSpeedTest{
-			Timestamp:    result.Timestamp.Format(time.RFC3339),
-			PingMs:       result.Ping.Latency,
-			DownloadMbps: downloadMbps,
-			UploadMbps:   uploadMbps,
-		}, nil
 	}
 
-	return nil, fmt.Errorf("no valid speed test result found in output")
-}
-
-func runSpeedTest() (*FormattedSpeedTest, error) {
-	speedtest := exec.Command("speedtest", "--progress=no", "--format=json")
-	output, err := speedtest.CombinedOutput()
+	// Make sure no other instance writes to the same output
+	lock, err := lockFor(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("error running speedtest: %w\nOutput: %s", err, string(output))
+		slog.Error("Failed to lock output", "error", err)
+		return 1
 	}
 
-	return parseSpeedTestOutput(output)
-}
-
-func runSpeedTestWithRetry(maxRetries int, retryDelay time.Duration) (*FormattedSpeedTest, error) {
-	var lastErr error
-	for i := 0; i < maxRetries; i++ {
-		if i > 0 {
-			log.Printf("Retry attempt %d/%d after error: %v", i+1, maxRetries, lastErr)
-			time.Sleep(retryDelay)
+	// Initialize output files
+	sinks, err := openSinks(cfg)
+	if err != nil {
+		lock.Close()
+		slog.Error("Failed to initialize output", "error", err)
+		return 1
+	}
+	m := &monitor{
+		cfg:     cfg,
+		testers: cfg.speedTesters(),
+		label:   cfg.resultLabel(),
+		sinks:   sinks,
+		lock:    lock,
+		window:  newRollingWindow(cfg.Window),
+
+		lastResults: make(map[string]*speedtest.FormattedSpeedTest),
+		failures:    make(map[string]int),
+	}
+	if cfg.EMAAlpha > 0 {
+		m.smoothed = newSmoothedMetrics(cfg.EMAAlpha)
+	}
+	if cfg.MetricsAddr != "" {
+		metrics := newMetricsServer(cfg)
+		metrics.Start()
+		m.servers = append(m.servers, metrics)
+	}
+	var status *StatusServer
+	if cfg.HTTPAddr != "" {
+		status = newStatusServer(cfg)
+		status.Start()
+		m.servers = append(m.servers, status)
+	}
+	defer func() {
+		closeSinks(m.sinks)
+		closeSinks(m.servers)
+		m.lock.Close()
+	}()
+	if cfg.Socket != "" {
+		socket, err := newSocketServer(cfg.Socket)
+		if err != nil {
+			slog.Error("Failed to set up socket", "error", err)
+			return 1
 		}
-
-		result, err := runSpeedTest()
-		if err == nil {
-			if i > 0 {
-				log.Printf("Successfully completed speed test after %d retries", i)
-			}
-			return result, nil
+		socket.Start()
+		m.servers = append(m.servers, socket)
+	}
+	if cfg.ErrorLog != "" {
+		errorLog, err := newErrorLog(cfg.ErrorLog)
+		if err != nil {
+			slog.Error("Failed to open error log", "error", err)
+			return 1
 		}
-		lastErr = err
-		log.Printf("Speed test attempt failed: %v", err)
+		defer errorLog.Close()
+		m.errorLog = errorLog
 	}
-	return nil, fmt.Errorf("failed after %d retries, last error: %v", maxRetries, lastErr)
-}
-
-func main() {
-	// Set up logging
-	log.SetFlags(log.LstdFlags | log.Lmicroseconds)
-	log.Println("Starting speedtest monitoring service...")
+	if cfg.SMTPHost != "" {
+		m.notifiers = append(m.notifiers, newEmailNotifier(cfg))
+	}
+	if cfg.SlackWebhook != "" {
+		m.notifiers = append(m.notifiers, newSlackNotifier(cfg.SlackWebhook))
+	}
+	m.restoreState()
 
-	// Initialize CSV file
-	csvFile, err := ensureCSVFile("output.csv")
+	// Schedule tests every interval (default 30 minutes to avoid overloading) or by cron expression
+	schedule, err := newScheduler(cfg)
 	if err != nil {
-		log.Fatalf("Failed to initialize CSV file: %v", err)
+		slog.Error("Failed to set up schedule", "error", err)
+		return 1
+	}
+	defer func() { schedule.Stop() }()
+
+	// Set up signal handling for graceful shutdown. The signal cancels ctx,
+	// which stops an in-flight test; results already obtained are still
+	// written and flushed before run returns.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if status != nil {
+		status.setRunTest(func() ([]*speedtest.FormattedSpeedTest, bool, error) {
+			return m.tryRunTest(ctx)
+		})
 	}
-	defer csvFile.Close()
-	csvWriter := csv.NewWriter(csvFile)
-	defer csvWriter.Flush()
-
-	// Create a ticker that triggers every 30 minutes (to avoid overloading)
-	ticker := time.NewTicker(10 * time.Minute)
-	defer ticker.Stop()
-
-	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	reloadChan := make(chan os.Signal, 1)
+	signal.Notify(reloadChan, syscall.SIGHUP)
+	// SIGUSR1 toggles pausing the schedule, SIGUSR2 runs a test right away
+	pauseChan := make(chan os.Signal, 1)
+	signal.Notify(pauseChan, syscall.SIGUSR1)
+	forceChan := make(chan os.Signal, 1)
+	signal.Notify(forceChan, syscall.SIGUSR2)
+	go func() {
+		sig := <-sigChan
+		slog.Info("Received signal, shutting down...", "signal", sig)
+		cancel()
+		time.AfterFunc(shutdownTimeout, func() {
+			slog.Error("Shutdown did not complete in time, exiting", "timeout", shutdownTimeout)
+			os.Exit(1)
+		})
+	}()
+
+	if cfg.PrecheckHost != "" {
+		precheckConnectivity(ctx, cfg.PrecheckHost, cfg.ipVersion())
+	}
+
+	if cfg.Warmup {
+		m.warmup(ctx)
+	}
 
 	// Run first test immediately with retry logic
-	if result, err := runSpeedTestWithRetry(3, 1*time.Minute); err != nil {
-		log.Printf("Error after retries: %v", err)
-	} else {
-		// Log JSON to console
-		jsonResult, _ := json.MarshalIndent(result, "", "    ")
-		log.Printf("Speed test results:\n%s", string(jsonResult))
-
-		// Write to CSV
-		if err := csvWriter.Write(result.toCSV()); err != nil {
-			log.Printf("Error writing to CSV: %v", err)
+	err = m.runTest(ctx)
+
+	// In single-shot mode the exit code reports whether the test succeeded
+	if cfg.Once {
+		if err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	// With -count, stop once that many tests have succeeded; failed tests
+	// don't use up the budget
+	successes := 0
+	countSuccess := func(err error) bool {
+		if err == nil {
+			successes++
 		}
-		csvWriter.Flush()
+		return cfg.Count > 0 && successes >= cfg.Count
+	}
+	if countSuccess(err) {
+		m.summary.log()
+		return 0
 	}
 
-	// Main loop
+	// Main loop. While paused or cooling down after a failure, scheduled ticks
+	// are skipped but a forced test still runs and records as usual.
+	paused := false
 	for {
 		select {
-		case <-ticker.C:
-			if result, err := runSpeedTestWithRetry(3, 1*time.Minute); err != nil {
-				log.Printf("Error after retries: %v", err)
+		case <-schedule.C():
+			if paused {
+				slog.Info("Skipping scheduled test while paused")
+				continue
+			}
+			if until, ok := m.coolingDown(); ok {
+				slog.Info("Skipping scheduled test during the failure cool-down", "until", until.Format(time.RFC3339))
+				continue
+			}
+			if countSuccess(m.runTest(ctx)) {
+				slog.Info("Completed the requested number of tests", "count", cfg.Count)
+				m.summary.log()
+				return 0
+			}
+		case <-pauseChan:
+			paused = !paused
+			if paused {
+				slog.Info("Paused scheduled tests; send SIGUSR1 again to resume")
+			} else {
+				slog.Info("Resumed scheduled tests")
+			}
+		case <-forceChan:
+			slog.Info("Running a test on request", "paused", paused)
+			if countSuccess(m.runTest(ctx)) {
+				slog.Info("Completed the requested number of tests", "count", cfg.Count)
+				m.summary.log()
+				return 0
+			}
+		case <-reloadChan:
+			if s, err := m.reload(os.Args[1:]); err != nil {
+				slog.Error("Config reload failed, keeping the current settings", "error", err)
 			} else {
-				// Log JSON to console
-				jsonResult, _ := json.MarshalIndent(result, "", "    ")
-				log.Printf("Speed test results:\n%s", string(jsonResult))
-
-				// Write to CSV
-				if err := csvWriter.Write(result.toCSV()); err != nil {
-					log.Printf("Error writing to CSV: %v", err)
-				}
-				csvWriter.Flush()
+				schedule.Stop()
+				schedule = s
 			}
-		case sig := <-sigChan:
-			log.Printf("Received signal %v, shutting down...", sig)
-			return
+		case <-ctx.Done():
+			m.summary.log()
+			return 0
 		}
 	}
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"speedtest-cron/speedtest"
+)
+
+// resultGauges holds the Prometheus gauges describing the latest result of
+// every backend, in a registry of their own.
+type resultGauges struct {
+	registry    *prometheus.Registry
+	download    *prometheus.GaugeVec
+	upload      *prometheus.GaugeVec
+	ping        *prometheus.GaugeVec
+	lastSuccess *prometheus.GaugeVec
+}
+
+func newResultGauges() *resultGauges {
+	labels := []string{"backend"}
+	g := &resultGauges{
+		download: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "speedtest_download_mbps",
+			Help: "Download bandwidth of the last successful speed test in Mbps.",
+		}, labels),
+		upload: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "speedtest_upload_mbps",
+			Help: "Upload bandwidth of the last successful speed test in Mbps.",
+		}, labels),
+		ping: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "speedtest_ping_ms",
+			Help: "Latency of the last successful speed test in milliseconds.",
+		}, labels),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "speedtest_last_success_timestamp",
+			Help: "Unix time of the last successful speed test.",
+		}, labels),
+	}
+	g.registry = prometheus.NewRegistry()
+	g.registry.MustRegister(g.download, g.upload, g.ping, g.lastSuccess)
+	return g
+}
+
+func (g *resultGauges) set(result *speedtest.FormattedSpeedTest) {
+	if !result.LatencyOnly {
+		g.download.WithLabelValues(result.Backend).Set(result.DownloadMbps)
+		g.upload.WithLabelValues(result.Backend).Set(result.UploadMbps)
+	}
+	g.ping.WithLabelValues(result.Backend).Set(result.PingMs)
+	g.lastSuccess.WithLabelValues(result.Backend).SetToCurrentTime()
+}
+
+// MetricsServer exposes the latest result as Prometheus gauges over HTTP.
+// It implements ResultSink so the gauges are updated alongside the other
+// outputs, and closing it shuts the HTTP server down. It shares the TLS and
+// basic auth settings of the status server.
+type MetricsServer struct {
+	*resultGauges
+	server *http.Server
+	// tlsCert and tlsKey serve HTTPS instead of HTTP when set.
+	tlsCert, tlsKey string
+}
+
+func newMetricsServer(cfg *Config) *MetricsServer {
+	m := &MetricsServer{
+		resultGauges: newResultGauges(),
+		tlsCert:      cfg.HTTPTLSCert,
+		tlsKey:       cfg.HTTPTLSKey,
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	var handler http.Handler = mux
+	if cfg.HTTPAuth != "" {
+		user, password, _ := strings.Cut(cfg.HTTPAuth, ":")
+		handler = requireBasicAuth(handler, user, password)
+	} else {
+		slog.Warn("Prometheus metrics are served without authentication; set -http-auth or keep -metrics-addr on a trusted network", "addr", cfg.MetricsAddr)
+	}
+	m.server = &http.Server{Addr: cfg.MetricsAddr, Handler: handler}
+	return m
+}
+
+// Start serves metrics in the background until Close is called.
+func (m *MetricsServer) Start() {
+	go func() {
+		var err error
+		if m.tlsCert != "" {
+			slog.Info("Serving Prometheus metrics over HTTPS", "addr", m.server.Addr, "path", "/metrics")
+			err = m.server.ListenAndServeTLS(m.tlsCert, m.tlsKey)
+		} else {
+			slog.Info("Serving Prometheus metrics", "addr", m.server.Addr, "path", "/metrics")
+			err = m.server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server error", "error", err)
+		}
+	}()
+}
+
+func (m *MetricsServer) Write(result *speedtest.FormattedSpeedTest) error {
+	m.set(result)
+	return nil
+}
+
+func (m *MetricsServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return m.server.Shutdown(ctx)
+}
+
+// MetricsFile rewrites a Prometheus text-format file with the gauges after
+// every result, for node_exporter's textfile collector. The file is replaced
+// atomically, so a scraper never reads it half-written.
+type MetricsFile struct {
+	*resultGauges
+	filename string
+}
+
+func newMetricsFile(filename string) (*MetricsFile, error) {
+	if err := ensureDir(filename); err != nil {
+		return nil, err
+	}
+	return &MetricsFile{resultGauges: newResultGauges(), filename: filename}, nil
+}
+
+func (m *MetricsFile) Write(result *speedtest.FormattedSpeedTest) error {
+	m.set(result)
+	if err := prometheus.WriteToTextfile(m.filename, m.registry); err != nil {
+		return fmt.Errorf("error writing metrics file: %w", err)
+	}
+	return nil
+}
+
+func (m *MetricsFile) Close() error {
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsServer(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.MetricsAddr = "127.0.0.1:0"
+	cfg.HTTPAuth = "admin:secret"
+	m := newMetricsServer(&cfg)
+	m.Write(testResult())
+
+	rec := httptest.NewRecorder()
+	m.server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("GET /metrics without credentials = %d, want 401", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.SetBasicAuth("admin", "secret")
+	rec = httptest.NewRecorder()
+	m.server.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /metrics = %d, want 200", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `speedtest_download_mbps{backend="ookla"} 100`) {
+		t.Errorf("GET /metrics is missing the download gauge of the ookla backend:\n%s", body)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sync"
+	"time"
+
+	"speedtest-cron/speedtest"
+)
+
+// monitor carries the state shared by the scheduled tests of one process.
+type monitor struct {
+	cfg     *Config
+	testers []speedtest.Tester
+	// label tags every result, see Config.resultLabel.
+	label string
+	sinks []ResultSink
+	// lock guards the output of cfg against other instances.
+	lock *outputLock
+	// servers are the metrics and status servers; unlike sinks they keep
+	// running across a reload.
+	servers   []ResultSink
+	notifiers []Notifier
+	// errorLog records final failures for -error-log; nil when disabled.
+	errorLog *ErrorLog
+	// lastResults holds the previous result of every backend, which change
+	// detection compares against; backends measure differently, so results
+	// are only ever compared within one backend.
+	lastResults map[string]*speedtest.FormattedSpeedTest
+	summary     sessionSummary
+	window      *rollingWindow
+	// smoothed is nil unless -ema-alpha is set.
+	smoothed *smoothedMetrics
+	// failures counts the consecutive failed runs of every backend since its
+	// last success.
+	failures map[string]int
+	// cooldownUntil is when scheduled tests resume after a failed run, see
+	// -failure-cooldown.
+	cooldownUntil time.Time
+	// running is held while a test runs or the config reloads, so a test
+	// requested over HTTP never overlaps a scheduled one.
+	running sync.Mutex
+}
+
+// warmup runs one throwaway test on every backend, so connections are warm
+// before the first recorded test. Results are only logged and failures
+// don't count as failed tests.
+func (m *monitor) warmup(ctx context.Context) {
+	slog.Info("Running a warm-up test; its result is discarded, not recorded")
+	for _, tester := range m.testers {
+		result, err := speedtest.RunWithRetry(ctx, speedtest.RetryPolicy{MaxRetries: 1}, tester)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Warn("Warm-up test failed; continuing with recorded tests", "backend", tester.Name(), "error", err)
+			continue
+		}
+		slog.Info("Discarded warm-up test result", resultAttrs(result)...)
+	}
+}
+
+// runTest runs one speed test with retries on every backend and handles the
+// outcomes, waiting for a test already in progress to finish first.
+// Backends run concurrently and a failure in one never stops the others
+// from recording. The returned error is nil only if every backend recorded
+// a result.
+func (m *monitor) runTest(ctx context.Context) error {
+	m.running.Lock()
+	defer m.running.Unlock()
+	_, err := m.test(ctx)
+	return err
+}
+
+// tryRunTest is runTest for tests requested over HTTP: if another test is in
+// progress it returns false at once instead of waiting. Otherwise it also
+// returns the recorded results.
+func (m *monitor) tryRunTest(ctx context.Context) ([]*speedtest.FormattedSpeedTest, bool, error) {
+	if !m.running.TryLock() {
+		return nil, false, nil
+	}
+	defer m.running.Unlock()
+	results, err := m.test(ctx)
+	return results, true, err
+}
+
+// test does the work of runTest and returns the results that were recorded.
+// The caller must hold m.running.
+func (m *monitor) test(ctx context.Context) ([]*speedtest.FormattedSpeedTest, error) {
+	results := make([]*speedtest.FormattedSpeedTest, len(m.testers))
+	errs := make([]error, len(m.testers))
+	var wg sync.WaitGroup
+	for i, tester := range m.testers {
+		wg.Add(1)
+		go func(i int, tester speedtest.Tester) {
+			defer wg.Done()
+			results[i], errs[i] = speedtest.RunWithRetry(ctx, m.cfg.retryPolicy(), tester)
+		}(i, tester)
+	}
+	wg.Wait()
+	interrupted := ctx.Err() != nil
+	if interrupted {
+		slog.Info("Speed test interrupted by shutdown, recording the backends that finished")
+	}
+
+	// Handle outcomes in backend order so outputs stay deterministic
+	var recorded []*speedtest.FormattedSpeedTest
+	for i, tester := range m.testers {
+		switch {
+		case errs[i] != nil && interrupted && errors.Is(errs[i], ctx.Err()):
+			// Stopped by the shutdown, which is no failure of the backend
+			slog.Info("Speed test cancelled", "backend", tester.Name())
+			errs[i] = &backendError{backend: tester.Name(), err: errs[i]}
+		case errs[i] != nil:
+			m.logError(tester.Name(), errs[i])
+			errs[i] = &backendError{backend: tester.Name(), err: errs[i]}
+			m.handleFailure(tester.Name(), errs[i])
+		default:
+			results[i].Label = m.label
+			results[i].Tags = m.cfg.Tags.values()
+			results[i].RecordedAt = time.Now().In(m.cfg.location()).Format(time.RFC3339)
+			m.handleResult(results[i])
+			recorded = append(recorded, results[i])
+		}
+	}
+	m.saveState()
+	err := errors.Join(errs...)
+	if err != nil && m.cfg.FailureCooldown > 0 && !interrupted {
+		m.cooldownUntil = time.Now().Add(m.cfg.FailureCooldown)
+		slog.Info("Cooling down after a failed test, skipping scheduled tests until then", "until", m.cooldownUntil.Format(time.RFC3339))
+	}
+	return recorded, err
+}
+
+// backendError is the error of one backend in the error returned by
+// monitor.test, which joins those of all failed backends.
+type backendError struct {
+	backend string
+	err     error
+}
+
+func (e *backendError) Error() string { return e.backend + ": " + e.err.Error() }
+
+func (e *backendError) Unwrap() error { return e.err }
+
+// backendErrors splits an error returned by monitor.test into the errors of
+// the individual backends.
+func backendErrors(err error) []*backendError {
+	var errs []error
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	} else if err != nil {
+		errs = []error{err}
+	}
+	var backendErrs []*backendError
+	for _, err := range errs {
+		var backendErr *backendError
+		if !errors.As(err, &backendErr) {
+			backendErr = &backendError{err: err}
+		}
+		backendErrs = append(backendErrs, backendErr)
+	}
+	return backendErrs
+}
+
+// coolingDown reports whether scheduled tests are still held back after a
+// failed run, and until when.
+func (m *monitor) coolingDown() (time.Time, bool) {
+	m.running.Lock()
+	defer m.running.Unlock()
+	return m.cooldownUntil, time.Now().Before(m.cooldownUntil)
+}
+
+// restoreState picks up the previous results and failure counts from
+// -state-file. A missing or unreadable file means starting fresh.
+func (m *monitor) restoreState() {
+	if m.cfg.StateFile == "" {
+		return
+	}
+	state, err := loadState(m.cfg.StateFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("No state file found, starting fresh", "file", m.cfg.StateFile)
+		return
+	}
+	if err != nil {
+		slog.Warn("Ignoring unreadable state file, starting fresh", "file", m.cfg.StateFile, "error", err)
+		return
+	}
+	// Files from before per-backend state describe the first backend
+	state.migrate(m.testers[0].Name())
+	for backend, result := range state.LastResults {
+		m.lastResults[backend] = result
+	}
+	for backend, failures := range state.Failures {
+		m.failures[backend] = failures
+	}
+	slog.Info("Restored state", "file", m.cfg.StateFile, "failures", m.failures, "backends_with_last_result", len(m.lastResults))
+}
+
+// saveState records the current state in -state-file, if configured.
+func (m *monitor) saveState() {
+	if m.cfg.StateFile == "" {
+		return
+	}
+	state := &monitorState{LastResults: m.lastResults, Failures: m.failures}
+	if err := saveState(m.cfg.StateFile, state); err != nil {
+		slog.Error("Error saving state", "file", m.cfg.StateFile, "error", err)
+	}
+}
+
+// logError appends a final failure of backend to -error-log, if configured.
+func (m *monitor) logError(backend string, err error) {
+	if m.errorLog == nil {
+		return
+	}
+	timestamp := time.Now().In(m.cfg.location()).Format(time.RFC3339)
+	if werr := m.errorLog.Write(newErrorLogEntry(timestamp, backend, err)); werr != nil {
+		slog.Error("Error writing error log", "error", werr)
+	}
+}
+
+func (m *monitor) handleFailure(backend string, err error) {
+	m.summary.addFailure()
+	m.failures[backend]++
+	if errors.Is(err, speedtest.ErrBinaryNotFound) {
+		slog.Warn("Speed test binary is missing, skipping this test; it is looked up again on the next one", "error", err)
+	} else {
+		slog.Error("Speed test failed after retries", "error", err)
+	}
+	notifyAll(m.notifiers, failureNotification(err, m.lastResults[backend]))
+}
+
+// handleResult logs a successful result, checks it against the thresholds
+// and passes it to every configured output.
+func (m *monitor) handleResult(result *speedtest.FormattedSpeedTest) {
+	slog.Info("Speed test results", resultAttrs(result)...)
+	m.summary.addResult(result)
+	if failures := m.failures[result.Backend]; failures > 0 {
+		notifyAll(m.notifiers, recoveryNotification(result, failures))
+		delete(m.failures, result.Backend)
+	}
+
+	m.window.Add(result)
+	avgDownload, avgUpload, avgPing := m.window.Average()
+	slog.Info("Rolling average",
+		"window", m.window.Len(),
+		"download_mbps", result.DownloadMbps, "avg_download_mbps", avgDownload,
+		"upload_mbps", result.UploadMbps, "avg_upload_mbps", avgUpload,
+		"ping_ms", result.PingMs, "avg_ping_ms", avgPing)
+	if m.smoothed != nil {
+		emaDownload, emaUpload, emaPing := m.smoothed.Add(result)
+		slog.Info("Smoothed results",
+			"alpha", m.smoothed.ping.alpha,
+			"download_mbps", result.DownloadMbps, "ema_download_mbps", emaDownload,
+			"upload_mbps", result.UploadMbps, "ema_upload_mbps", emaUpload,
+			"ping_ms", result.PingMs, "ema_ping_ms", emaPing)
+	}
+
+	// Warn about threshold breaches
+	if violations := checkThresholds(result, m.cfg); len(violations) > 0 {
+		for _, violation := range violations {
+			slog.Warn("Threshold breached", append([]any{"violation", violation}, resultAttrs(result)...)...)
+		}
+		notifyAll(m.notifiers, breachNotification(result, violations))
+	}
+
+	// A distant auto-selected server can explain a slow result
+	if far := checkServerDistance(result, m.cfg.MaxServerDistance); far != "" {
+		slog.Warn("Speed test used a distant server; consider pinning a closer one with -server-id", "reason", far, "server_id", result.ServerID)
+	}
+
+	// Warn about sudden drops relative to the previous result of this backend
+	previous := m.lastResults[result.Backend]
+	for _, drop := range detectDrops(previous, result, m.cfg.ChangeThreshold) {
+		slog.Warn("Sudden change detected", "change", drop)
+	}
+
+	// A new ISP name usually means a failover or reassignment
+	if ispChanged(previous, result) {
+		slog.Warn("ISP changed since the previous test", "previous_isp", previous.ISP, "isp", result.ISP)
+		notifyAll(m.notifiers, ispChangeNotification(previous, result))
+	}
+
+	// Write to the configured outputs
+	writeSinks(m.sinks, result)
+	writeSinks(m.servers, result)
+	m.lastResults[result.Backend] = result
+}
+
+// reload re-reads the configuration from args and the config file, as on
+// SIGHUP, and applies the schedule, thresholds, retries and outputs. It
+// returns the new scheduler for the caller to swap in. The old outputs are
+// flushed and closed before the new ones open, as both may be the same
+// files, and a new output path is locked first. On error the previous
+// outputs are reopened and nothing else is changed. Backends, the servers,
+// logging, notifications, the label, the rolling window size and the EMA
+// alpha still need a restart.
+func (m *monitor) reload(args []string) (scheduler, error) {
+	m.running.Lock()
+	defer m.running.Unlock()
+
+	cfg, err := loadConfig(args)
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := newScheduler(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up schedule: %w", err)
+	}
+	lock := m.lock
+	if !m.lock.covers(cfg) {
+		if lock, err = lockFor(cfg); err != nil {
+			schedule.Stop()
+			return nil, fmt.Errorf("error locking output: %w", err)
+		}
+	}
+
+	closeSinks(m.sinks)
+	sinks, err := openSinks(cfg)
+	if err != nil {
+		schedule.Stop()
+		if lock != m.lock {
+			lock.Close()
+		}
+		// Keep recording to the previous outputs
+		var reopenErr error
+		if m.sinks, reopenErr = openSinks(m.cfg); reopenErr != nil {
+			slog.Error("Error reopening the previous outputs, results are not recorded until the next reload", "error", reopenErr)
+		}
+		return nil, fmt.Errorf("error opening outputs: %w", err)
+	}
+	if lock != m.lock {
+		m.lock.Close()
+		m.lock = lock
+	}
+	m.sinks = sinks
+	m.cfg = cfg
+	slog.Info("Reloaded configuration", "interval", cfg.Interval, "cron", cfg.Cron, "output", cfg.Output, "format", cfg.Format)
+	return schedule, nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"speedtest-cron/speedtest"
+)
+
+// fakeTester is a speedtest.Tester returning canned outcomes.
+type fakeTester struct {
+	name string
+	run  func(ctx context.Context) (*speedtest.FormattedSpeedTest, error)
+}
+
+func (t *fakeTester) Name() string { return t.name }
+
+func (t *fakeTester) Run(ctx context.Context) (*speedtest.FormattedSpeedTest, error) {
+	return t.run(ctx)
+}
+
+// recordingSink keeps every result written to it.
+type recordingSink struct {
+	results []*speedtest.FormattedSpeedTest
+}
+
+func (s *recordingSink) Write(result *speedtest.FormattedSpeedTest) error {
+	s.results = append(s.results, result)
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+func newTestMonitor(testers ...speedtest.Tester) (*monitor, *recordingSink) {
+	cfg := defaultConfig()
+	cfg.Retries = 1
+	sink := &recordingSink{}
+	return &monitor{
+		cfg:         &cfg,
+		testers:     testers,
+		sinks:       []ResultSink{sink},
+		window:      newRollingWindow(cfg.Window),
+		lastResults: make(map[string]*speedtest.FormattedSpeedTest),
+		failures:    make(map[string]int),
+	}, sink
+}
+
+func TestMonitorRecordsFinishedBackendsOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	finished := &fakeTester{name: "ookla", run: func(ctx context.Context) (*speedtest.FormattedSpeedTest, error) {
+		// Shut down while the other backend is still running
+		cancel()
+		return testResult(), nil
+	}}
+	cancelled := &fakeTester{name: "librespeed", run: func(ctx context.Context) (*speedtest.FormattedSpeedTest, error) {
+		<-ctx.Done()
+		return nil, fmt.Errorf("librespeed-cli cancelled: %w", ctx.Err())
+	}}
+	m, sink := newTestMonitor(finished, cancelled)
+
+	recorded, err := m.test(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("test() error = %v, want context.Canceled", err)
+	}
+	if len(recorded) != 1 || len(sink.results) != 1 || sink.results[0].Backend != "ookla" {
+		t.Fatalf("recorded %v and wrote %v, want the ookla result", recorded, sink.results)
+	}
+	if m.lastResults["ookla"] == nil {
+		t.Error("the ookla result is not kept as its last result")
+	}
+	if len(m.failures) != 0 {
+		t.Errorf("failures = %v, want a cancelled backend not to count as failed", m.failures)
+	}
+}
+
+// recordingNotifier keeps every notification sent through it.
+type recordingNotifier struct {
+	sent []Notification
+}
+
+func (n *recordingNotifier) Notify(notification Notification) error {
+	n.sent = append(n.sent, notification)
+	return nil
+}
+
+func TestMonitorComparesISPWithinBackend(t *testing.T) {
+	isps := map[string][]string{"ookla": {"Acme", "Acme", "Globex"}, "librespeed": {"AS1 Acme", "AS1 Acme", "AS1 Acme"}}
+	var testers []speedtest.Tester
+	for _, name := range []string{"ookla", "librespeed"} {
+		name := name
+		tick := 0
+		testers = append(testers, &fakeTester{name: name, run: func(ctx context.Context) (*speedtest.FormattedSpeedTest, error) {
+			result := testResult()
+			result.ISP = isps[name][tick]
+			tick++
+			return result, nil
+		}})
+	}
+	m, _ := newTestMonitor(testers...)
+	notifier := &recordingNotifier{}
+	m.notifiers = []Notifier{notifier}
+
+	for tick := 0; tick < 3; tick++ {
+		if _, err := m.test(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var changes []Notification
+	for _, n := range notifier.sent {
+		if n.Kind == NotifyISPChange {
+			changes = append(changes, n)
+		}
+	}
+	if len(changes) != 1 || !strings.Contains(changes[0].Message, `"Globex"`) {
+		t.Errorf("ISP change notifications = %v, want one for ookla switching to Globex", changes)
+	}
+}
+
+func TestMonitorCoolingDown(t *testing.T) {
+	failing := &fakeTester{name: "ookla", run: func(ctx context.Context) (*speedtest.FormattedSpeedTest, error) {
+		return nil, errors.New("error running speedtest: exit status 2")
+	}}
+	m, _ := newTestMonitor(failing)
+	m.cfg.FailureCooldown = time.Hour
+
+	if _, ok := m.coolingDown(); ok {
+		t.Fatal("coolingDown() before any test = true")
+	}
+	start := time.Now()
+	if err := m.runTest(context.Background()); err == nil {
+		t.Fatal("runTest() with a failing backend succeeded")
+	}
+	until, ok := m.coolingDown()
+	if !ok || until.Before(start.Add(time.Hour)) {
+		t.Errorf("coolingDown() = %v, %v, want true until an hour after the failure", until, ok)
+	}
+}
+
+func TestMonitorReloadKeepsBufferedRows(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "results.csv.gz")
+	args := []string{"-output", output, "-batch", "3"}
+	cfg, err := loadConfig(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := newTestMonitor()
+	m.cfg = cfg
+	if m.lock, err = lockFor(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if m.sinks, err = openSinks(cfg); err != nil {
+		t.Fatal(err)
+	}
+	// Two rows stay buffered until the batch of three is full
+	writeSinks(m.sinks, testResult())
+	writeSinks(m.sinks, testResult())
+
+	if _, err := m.reload(args); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	writeSinks(m.sinks, testResult())
+	closeSinks(m.sinks)
+	defer m.lock.Close()
+
+	results, err := readCSVResults(output, ',', nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Errorf("got %d rows after reloading, want 3", len(results))
+	}
+}
+
+func TestMonitorReloadMovesLock(t *testing.T) {
+	dir := t.TempDir()
+	oldOutput, newOutput := filepath.Join(dir, "old.csv"), filepath.Join(dir, "new.csv")
+	cfg, err := loadConfig([]string{"-output", oldOutput})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := newTestMonitor()
+	m.cfg = cfg
+	if m.lock, err = lockFor(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if m.sinks, err = openSinks(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.reload([]string{"-output", newOutput}); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	defer closeSinks(m.sinks)
+	defer m.lock.Close()
+
+	if _, err := lockOutput(newOutput); err == nil {
+		t.Error("the new output is not locked after reloading")
+	}
+	lock, err := lockOutput(oldOutput)
+	if err != nil {
+		t.Errorf("the old output is still locked after reloading: %v", err)
+	} else {
+		lock.Close()
+	}
+}
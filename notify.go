@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"speedtest-cron/speedtest"
+)
+
+// NotificationKind tells apart the events a notification can report.
+type NotificationKind int
+
+const (
+	NotifyFailure NotificationKind = iota
+	NotifyBreach
+	NotifyRecovery
+	NotifyISPChange
+)
+
+// Notification is an alert about a failed test, a threshold breach, a
+// recovery after failures or a change of ISP.
+type Notification struct {
+	Kind    NotificationKind
+	Subject string
+	Message string
+}
+
+// Notifier delivers notifications to an external channel.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// notifyAll sends n through every notifier. Delivery failures are logged and
+// never interrupt monitoring.
+func notifyAll(notifiers []Notifier, n Notification) {
+	for _, notifier := range notifiers {
+		if err := notifier.Notify(n); err != nil {
+			slog.Error("Error sending notification", "subject", n.Subject, "error", err)
+		}
+	}
+}
+
+// EmailNotifier sends notifications as plain-text email over SMTP.
+type EmailNotifier struct {
+	addr     string
+	from     string
+	to       []string
+	username string
+	password string
+}
+
+func newEmailNotifier(cfg *Config) *EmailNotifier {
+	addr := cfg.SMTPHost
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "25")
+	}
+	var to []string
+	for _, recipient := range strings.Split(cfg.SMTPTo, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			to = append(to, recipient)
+		}
+	}
+	return &EmailNotifier{
+		addr:     addr,
+		from:     cfg.SMTPFrom,
+		to:       to,
+		username: cfg.SMTPUser,
+		password: cfg.SMTPPassword,
+	}
+}
+
+func (e *EmailNotifier) Notify(n Notification) error {
+	conn, err := net.DialTimeout("tcp", e.addr, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("error connecting to SMTP server: %w", err)
+	}
+	// Bound the whole conversation so a stuck server can't stall the monitor
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	host, _, _ := net.SplitHostPort(e.addr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error starting SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(nil); err != nil {
+			return fmt.Errorf("error starting TLS: %w", err)
+		}
+	}
+	if e.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.username, e.password, host)); err != nil {
+			return fmt.Errorf("error authenticating with SMTP server: %w", err)
+		}
+	}
+	if err := client.Mail(e.from); err != nil {
+		return fmt.Errorf("error setting sender: %w", err)
+	}
+	for _, recipient := range e.to {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("error adding recipient %s: %w", recipient, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("error starting message: %w", err)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s\r\n",
+		e.from, strings.Join(e.to, ", "), n.Subject, strings.ReplaceAll(n.Message, "\n", "\r\n"))
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return fmt.Errorf("error writing message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("error sending message: %w", err)
+	}
+	return client.Quit()
+}
+
+func failureNotification(err error, lastResult *speedtest.FormattedSpeedTest) Notification {
+	msg := fmt.Sprintf("The speed test failed after all retries:\n\n%v\n\n", err)
+	if lastResult != nil {
+		msg += fmt.Sprintf("Last successful test at %s: download %.2f Mbps, upload %.2f Mbps, ping %.2f ms.",
+			lastResult.Timestamp, lastResult.DownloadMbps, lastResult.UploadMbps, lastResult.PingMs)
+	} else {
+		msg += "No test has succeeded since the monitor started."
+	}
+	return Notification{Kind: NotifyFailure, Subject: "Speed test failed", Message: msg}
+}
+
+func breachNotification(result *speedtest.FormattedSpeedTest, violations []string) Notification {
+	msg := fmt.Sprintf("The speed test at %s breached the configured thresholds:\n\n- %s\n\n"+
+		"Download %.2f Mbps, upload %.2f Mbps, ping %.2f ms (server %s, ISP %s).",
+		result.Timestamp, strings.Join(violations, "\n- "),
+		result.DownloadMbps, result.UploadMbps, result.PingMs, result.ServerName, result.ISP)
+	return Notification{Kind: NotifyBreach, Subject: "Speed test thresholds breached", Message: msg}
+}
+
+func ispChangeNotification(previous, result *speedtest.FormattedSpeedTest) Notification {
+	msg := fmt.Sprintf("The speed test at %s reported ISP %q, but the previous test at %s reported %q. "+
+		"The connection may have failed over to a backup line or been reassigned.\n\n"+
+		"Download %.2f Mbps, upload %.2f Mbps, ping %.2f ms (server %s).",
+		result.Timestamp, result.ISP, previous.Timestamp, previous.ISP,
+		result.DownloadMbps, result.UploadMbps, result.PingMs, result.ServerName)
+	return Notification{Kind: NotifyISPChange, Subject: "ISP changed", Message: msg}
+}
+
+func recoveryNotification(result *speedtest.FormattedSpeedTest, failures int) Notification {
+	msg := fmt.Sprintf("The speed test at %s succeeded after %d failed run(s).\n\n"+
+		"Download %.2f Mbps, upload %.2f Mbps, ping %.2f ms (server %s, ISP %s).",
+		result.Timestamp, failures,
+		result.DownloadMbps, result.UploadMbps, result.PingMs, result.ServerName, result.ISP)
+	return Notification{Kind: NotifyRecovery, Subject: "Speed test recovered", Message: msg}
+}
@@ -0,0 +1,722 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"speedtest-cron/speedtest"
+)
+
+// ResultSink receives every completed speed test result, to persist it in one
+// output format or pass it on.
+type ResultSink interface {
+	Write(result *speedtest.FormattedSpeedTest) error
+	Close() error
+}
+
+// bandwidthUnits maps each supported -units value to the suffix of the
+// download and upload CSV columns.
+var bandwidthUnits = map[string]string{
+	"mbps":    "mbps",  // megabits per second
+	"mbytes":  "mb_s",  // megabytes per second
+	"mibytes": "mib_s", // mebibytes per second
+}
+
+// convertBandwidth converts a rate in megabits per second to unit.
+func convertBandwidth(mbps float64, unit string) float64 {
+	switch unit {
+	case "mbytes":
+		return mbps / 8
+	case "mibytes":
+		return mbps * 1_000_000 / 8 / (1 << 20)
+	default:
+		return mbps
+	}
+}
+
+// csvHeader returns the CSV column names, with the bandwidth columns named
+// after unit and one column per tag key at the end.
+func csvHeader(unit string, tagKeys []string) []string {
+	suffix := bandwidthUnits[unit]
+	header := []string{
+		"timestamp", "ping_ms", "download_" + suffix, "upload_" + suffix, "jitter_ms", "packet_loss",
+		"server_name", "server_id", "isp", "backend", "label", "duration_ms", "recorded_at",
+	}
+	return append(header, tagKeys...)
+}
+
+// toCSV formats the result as a CSV row, converting bandwidth to the
+// configured unit and appending the values of the tag columns.
+func toCSV(f *speedtest.FormattedSpeedTest, opts csvOptions) []string {
+	// Latency-only tests leave the bandwidth columns empty
+	download, upload := "", ""
+	if !f.LatencyOnly {
+		download = opts.formatFloat(convertBandwidth(f.DownloadMbps, opts.unit))
+		upload = opts.formatFloat(convertBandwidth(f.UploadMbps, opts.unit))
+	}
+	row := []string{
+		f.Timestamp,
+		opts.formatFloat(f.PingMs),
+		download,
+		upload,
+		opts.formatFloat(f.JitterMs),
+		opts.formatFloat(f.PacketLoss),
+		f.ServerName,
+		f.ServerID,
+		f.ISP,
+		f.Backend,
+		f.Label,
+		strconv.FormatInt(f.DurationMs, 10),
+		f.RecordedAt,
+	}
+	for _, key := range opts.tags {
+		row = append(row, f.Tags[key])
+	}
+	return row
+}
+
+// formatFloat formats a measurement with the configured number of decimals
+// and decimal separator.
+func (o csvOptions) formatFloat(v float64) string {
+	s := strconv.FormatFloat(v, 'f', o.precision, 64)
+	if o.decimalComma {
+		s = strings.Replace(s, ".", ",", 1)
+	}
+	return s
+}
+
+// errCSVHeaderMismatch is returned by checkCSVHeader when an existing file
+// was written with a different column layout.
+var errCSVHeaderMismatch = errors.New("CSV header mismatch")
+
+// ensureCSVFile opens filename for appending, writing the header first if the
+// file is new or empty. An existing file with a different header is an error,
+// unless force is set, in which case it is moved aside and a new one started.
+// A nil header is never written or checked.
+func ensureCSVFile(filename string, header []string, opts csvOptions) (*os.File, error) {
+	if info, err := os.Stat(filename); err == nil && info.Size() > 0 {
+		if header == nil {
+			return os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+		}
+		err := checkCSVHeader(filename, header, opts.comma)
+		if err == nil {
+			return os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0644)
+		}
+		if !opts.force || !errors.Is(err, errCSVHeaderMismatch) {
+			return nil, err
+		}
+		rotated := nextRotatedFilename(filename, time.Now())
+		if err := os.Rename(filename, rotated); err != nil {
+			return nil, fmt.Errorf("error moving old CSV file aside: %w", err)
+		}
+		slog.Warn("Moved CSV file with a different header aside", "file", filename, "moved_to", rotated)
+	} else if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error checking CSV file: %w", err)
+	}
+
+	if err := ensureDir(filename); err != nil {
+		return nil, err
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error creating CSV file: %w", err)
+	}
+	if header == nil {
+		return file, nil
+	}
+	writer := opts.newWriter(file)
+	if err := writer.Write(header); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error writing CSV header: %w", err)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error flushing CSV writer: %w", err)
+	}
+	return file, nil
+}
+
+// checkCSVHeader verifies that an existing CSV file was written with the
+// current column layout, so new rows are never appended under a stale header.
+func checkCSVHeader(filename string, expected []string, comma rune) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("error opening CSV file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error reading CSV header: %w", err)
+	}
+	if strings.Join(header, ",") != strings.Join(expected, ",") {
+		return fmt.Errorf("%w: %s has header %q but this version writes %q; move the old file aside, pass -force to do so automatically, or choose a different -output",
+			errCSVHeaderMismatch, filename, strings.Join(header, ","), strings.Join(expected, ","))
+	}
+	return nil
+}
+
+// csvOptions controls how CSV output is split across files.
+type csvOptions struct {
+	// maxSize rotates the file once it would grow past this many bytes.
+	maxSize int64
+	// daily writes to one file per day, named by dailyFilename.
+	daily bool
+	// unit is the -units value used for the bandwidth columns.
+	unit string
+	// force moves an existing file with a different header aside instead of
+	// failing.
+	force bool
+	// compress writes the file as a gzip stream, see openGzipCSV.
+	compress bool
+	// comma separates fields and crlf ends rows with \r\n.
+	comma rune
+	crlf  bool
+	// noHeader leaves out the header row, for files that are concatenated
+	// downstream.
+	noHeader bool
+	// batch is the number of rows buffered before they are flushed to disk.
+	batch int
+	// repair drops a partial last row before appending, see repairCSVFile.
+	repair bool
+	// retention prunes rotated files, see pruneRotatedCSVs.
+	retention keepPolicy
+	// tags are the -tag keys, written as extra columns after the built-in ones.
+	tags []string
+	// precision is the number of decimals of measurements, and decimalComma
+	// writes them with a decimal comma instead of a point.
+	precision    int
+	decimalComma bool
+}
+
+// header returns the header row to write, or nil with noHeader.
+func (o csvOptions) header() []string {
+	if o.noHeader {
+		return nil
+	}
+	return csvHeader(o.unit, o.tags)
+}
+
+// newWriter returns a CSV writer for w using the configured delimiter and
+// line endings.
+func (o csvOptions) newWriter(w io.Writer) *csv.Writer {
+	writer := csv.NewWriter(w)
+	writer.Comma = o.comma
+	writer.UseCRLF = o.crlf
+	return writer
+}
+
+// encodedSize returns the number of bytes record takes up once written.
+func (o csvOptions) encodedSize(record []string) int64 {
+	var buf bytes.Buffer
+	writer := o.newWriter(&buf)
+	writer.Write(record)
+	writer.Flush()
+	return int64(buf.Len())
+}
+
+// CSVWriter appends one row per result to a CSV file, optionally rotating
+// the file by size or switching to a new file every day.
+type CSVWriter struct {
+	// mu guards the file and writer, which rotate and switchFile swap out.
+	mu       sync.Mutex
+	base     string
+	opts     csvOptions
+	header   []string
+	filename string
+	// size is the size of the file on disk; pending rows taking up
+	// pendingSize bytes are buffered until the batch is full.
+	size        int64
+	pending     int
+	pendingSize int64
+	file        *os.File
+	// gz sits between writer and file when compressing.
+	gz     *gzip.Writer
+	writer *csv.Writer
+	// diskFull is set while writes fail with ENOSPC.
+	diskFull bool
+}
+
+func newCSVWriter(filename string, opts csvOptions) (*CSVWriter, error) {
+	w := &CSVWriter{base: filename, opts: opts, header: opts.header()}
+	w.filename = w.currentFilename(time.Now())
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	w.prune()
+	return w, nil
+}
+
+func (w *CSVWriter) currentFilename(now time.Time) string {
+	return csvFilename(w.base, w.opts.daily, now)
+}
+
+// csvFilename returns the file CSV rows are written to at time now.
+func csvFilename(base string, daily bool, now time.Time) string {
+	if daily {
+		return dailyFilename(base, now)
+	}
+	return base
+}
+
+// dailyFilename inserts the date before the extension, e.g. output.csv
+// becomes output-2024-06-01.csv.
+func dailyFilename(base string, t time.Time) string {
+	stem, ext := splitExt(base)
+	return stem + "-" + t.Format("2006-01-02") + ext
+}
+
+func (w *CSVWriter) open() error {
+	if w.opts.compress {
+		file, gz, err := openGzipCSV(w.filename, w.header, w.opts)
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			gz.Close()
+			file.Close()
+			return fmt.Errorf("error reading CSV file size: %w", err)
+		}
+		w.file, w.gz = file, gz
+		w.writer = w.opts.newWriter(gz)
+		w.size = info.Size()
+		return nil
+	}
+
+	if w.opts.repair {
+		if err := repairCSVFile(w.filename, w.opts.comma); err != nil {
+			return err
+		}
+	}
+	file, err := ensureCSVFile(w.filename, w.header, w.opts)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error reading CSV file size: %w", err)
+	}
+	w.file = file
+	w.writer = w.opts.newWriter(file)
+	w.size = info.Size()
+	return nil
+}
+
+func (w *CSVWriter) Write(result *speedtest.FormattedSpeedTest) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if name := w.currentFilename(time.Now()); name != w.filename {
+		if err := w.switchFile(name); err != nil {
+			slog.Error("Error switching CSV file", "file", name, "error", err)
+		}
+	}
+
+	row := toCSV(result, w.opts)
+	rowSize := w.opts.encodedSize(row)
+	if w.opts.maxSize > 0 && w.size+w.pendingSize > w.headerSize() && w.size+w.pendingSize+rowSize > w.opts.maxSize {
+		if err := w.rotate(); err != nil {
+			// Keep appending to the current file rather than dropping the row
+			slog.Error("Error rotating CSV file", "file", w.filename, "error", err)
+		}
+	}
+
+	w.writer.Write(row)
+	w.pending++
+	w.pendingSize += rowSize
+	if w.pending < w.opts.batch {
+		return nil
+	}
+	return w.flush()
+}
+
+// flush writes the buffered rows out to the file. Error reports a failure
+// from either the earlier writes or the flush, such as a full disk.
+func (w *CSVWriter) flush() error {
+	w.writer.Flush()
+	err := w.writer.Error()
+	if err == nil && w.gz != nil {
+		err = w.gz.Flush()
+	}
+	if err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			return w.handleDiskFull()
+		}
+		return fmt.Errorf("error writing to CSV: %w", err)
+	}
+	if w.diskFull {
+		slog.Info("Disk space available again, CSV writes resumed", "file", w.filename)
+		w.diskFull = false
+	}
+	if w.gz != nil {
+		// Rows shrink by an unknown amount, so track the size on disk
+		if info, err := w.file.Stat(); err == nil {
+			w.size = info.Size()
+		}
+	} else {
+		w.size += w.pendingSize
+	}
+	w.pending, w.pendingSize = 0, 0
+	return nil
+}
+
+// headerSize returns the size of a file holding only the header.
+func (w *CSVWriter) headerSize() int64 {
+	if w.header == nil {
+		return 0
+	}
+	return w.opts.encodedSize(w.header)
+}
+
+// handleDiskFull cleans up after a write failed because the disk is full:
+// any partially written row is cut off again so the file stays parseable, and
+// the CSV writer, whose error is sticky, is replaced so the next result is
+// tried afresh. Tests, metrics and the other outputs carry on meanwhile.
+func (w *CSVWriter) handleDiskFull() error {
+	lost := w.pending
+	w.pending, w.pendingSize = 0, 0
+	if w.gz != nil {
+		// The gzip stream can't be rewound; it is rebuilt on restart
+		w.diskFull = true
+		return fmt.Errorf("disk full writing to %s: free up space on the device and restart; compressed output can't resume in place", w.filename)
+	}
+	if err := w.file.Truncate(w.size); err != nil {
+		slog.Error("Error removing partial CSV row", "file", w.filename, "error", err)
+	}
+	w.writer = w.opts.newWriter(w.file)
+	w.diskFull = true
+	return fmt.Errorf("disk full writing to %s: free up space on the device; %d row(s) were not saved to CSV and writing resumes with the next result that fits", w.filename, lost)
+}
+
+// rotate moves the current file aside under a timestamped name and starts a
+// fresh one with a new header. Buffered rows are flushed into the old file
+// first, and the file and writer are only swapped once the new file is open,
+// so a failed rotation never loses rows: the old handle, which now points at
+// the rotated file, stays in use. The caller must hold w.mu.
+func (w *CSVWriter) rotate() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	rotated := nextRotatedFilename(w.filename, time.Now())
+	if err := os.Rename(w.filename, rotated); err != nil {
+		return fmt.Errorf("error renaming CSV file: %w", err)
+	}
+
+	old, oldGz := w.file, w.gz
+	if err := w.open(); err != nil {
+		// The old handle now points at the rotated file; keep using it
+		return err
+	}
+	if err := closeCSVFile(old, oldGz); err != nil {
+		slog.Error("Error closing rotated CSV file", "file", rotated, "error", err)
+	}
+	slog.Info("Rotated CSV file", "file", w.filename, "rotated_to", rotated)
+	w.prune()
+	return nil
+}
+
+// switchFile closes the current file and continues in a new one, as happens
+// when the date changes in daily mode. The caller must hold w.mu.
+func (w *CSVWriter) switchFile(name string) error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	old, oldGz, oldName := w.file, w.gz, w.filename
+	w.filename = name
+	if err := w.open(); err != nil {
+		w.filename = oldName
+		return err
+	}
+	if err := closeCSVFile(old, oldGz); err != nil {
+		slog.Error("Error closing CSV file", "file", oldName, "error", err)
+	}
+	slog.Info("Switched CSV output", "file", name)
+	w.prune()
+	return nil
+}
+
+// prune applies -retention to the rotated files; failing to is only logged.
+func (w *CSVWriter) prune() {
+	if err := pruneRotatedCSVs(w.base, w.filename, w.opts.retention); err != nil {
+		slog.Error("Error pruning old CSV files", "error", err)
+	}
+}
+
+// rotatedFilename inserts a timestamp before the extension, e.g.
+// output.csv becomes output-20240601T150405.csv. A non-zero seq is appended
+// to tell apart rotations within the same second.
+func rotatedFilename(filename string, t time.Time, seq int) string {
+	stem, ext := splitExt(filename)
+	name := stem + "-" + t.Format("20060102T150405")
+	if seq > 0 {
+		name += "-" + strconv.Itoa(seq)
+	}
+	return name + ext
+}
+
+// nextRotatedFilename returns the first rotatedFilename for t that is not
+// taken yet, so an earlier rotation from the same second is never clobbered.
+func nextRotatedFilename(filename string, t time.Time) string {
+	rotated := rotatedFilename(filename, t, 0)
+	for i := 1; fileExists(rotated); i++ {
+		rotated = rotatedFilename(filename, t, i)
+	}
+	return rotated
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// Close flushes a partial batch before closing the file.
+func (w *CSVWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.flush(); err != nil {
+		closeCSVFile(w.file, w.gz)
+		return err
+	}
+	return closeCSVFile(w.file, w.gz)
+}
+
+// closeCSVFile finishes the gzip stream, if any, and closes the file.
+func closeCSVFile(file *os.File, gz *gzip.Writer) error {
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			file.Close()
+			return fmt.Errorf("error finishing compressed CSV file: %w", err)
+		}
+	}
+	return file.Close()
+}
+
+// stdoutPath is the -output value that streams results to standard output.
+const stdoutPath = "-"
+
+// StdoutCSVWriter streams CSV rows to standard output for use in shell
+// pipelines. The header is written once when it is created and every row is
+// flushed straight away.
+type StdoutCSVWriter struct {
+	opts   csvOptions
+	writer *csv.Writer
+}
+
+func newStdoutCSVWriter(opts csvOptions) (*StdoutCSVWriter, error) {
+	w := &StdoutCSVWriter{opts: opts, writer: opts.newWriter(os.Stdout)}
+	if header := opts.header(); header != nil {
+		w.writer.Write(header)
+		w.writer.Flush()
+		if err := w.writer.Error(); err != nil {
+			return nil, fmt.Errorf("error writing CSV header: %w", err)
+		}
+	}
+	return w, nil
+}
+
+func (w *StdoutCSVWriter) Write(result *speedtest.FormattedSpeedTest) error {
+	w.writer.Write(toCSV(result, w.opts))
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		return fmt.Errorf("error writing CSV to stdout: %w", err)
+	}
+	return nil
+}
+
+func (w *StdoutCSVWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// JSONLWriter appends one JSON object per line for each result.
+type JSONLWriter struct {
+	file    *os.File
+	encoder *json.Encoder
+	// fsync syncs the file to disk after every result.
+	fsync bool
+}
+
+func newJSONLWriter(filename string, fsync bool) (*JSONLWriter, error) {
+	if err := ensureDir(filename); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening JSONL file: %w", err)
+	}
+	return &JSONLWriter{file: file, encoder: json.NewEncoder(file), fsync: fsync}, nil
+}
+
+func (w *JSONLWriter) Write(result *speedtest.FormattedSpeedTest) error {
+	if err := w.encoder.Encode(result); err != nil {
+		return fmt.Errorf("error writing to JSONL: %w", err)
+	}
+	if w.fsync {
+		if err := w.file.Sync(); err != nil {
+			return fmt.Errorf("error syncing JSONL file: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the file, unless the writer is writing to stdout, which stays
+// open for the rest of the process. The encoder is unbuffered, so there is
+// nothing left to flush.
+func (w *JSONLWriter) Close() error {
+	if w.file == os.Stdout {
+		return nil
+	}
+	return w.file.Close()
+}
+
+// jsonlPath derives the JSON Lines filename from the CSV output path by
+// swapping its extension, e.g. output.csv becomes output.jsonl.
+func jsonlPath(output string) string {
+	stem, _ := splitExt(output)
+	return stem + ".jsonl"
+}
+
+// ensureDir creates the parent directories of filename if they are missing.
+func ensureDir(filename string) error {
+	if dir := filepath.Dir(filename); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("error creating output directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// openSinks opens the sinks selected by the format, database, Postgres,
+// metrics file, InfluxDB, webhook and StatsD settings. Sinks are called in order, so
+// file outputs come first.
+func openSinks(cfg *Config) ([]ResultSink, error) {
+	var sinks []ResultSink
+	switch {
+	case cfg.Output == stdoutPath && cfg.Format == "jsonl":
+		sinks = append(sinks, &JSONLWriter{file: os.Stdout, encoder: json.NewEncoder(os.Stdout)})
+	case cfg.Output == stdoutPath:
+		w, err := newStdoutCSVWriter(cfg.csvOptions())
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, w)
+	case cfg.Format == "csv" || cfg.Format == "both":
+		w, err := newCSVWriter(cfg.csvPath(), cfg.csvOptions())
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, w)
+	}
+	if cfg.Output != stdoutPath && (cfg.Format == "jsonl" || cfg.Format == "both") {
+		w, err := newJSONLWriter(jsonlPath(cfg.Output), cfg.Fsync)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, w)
+	}
+	if cfg.DB != "" {
+		w, err := newSQLiteWriter(cfg.DB)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, w)
+	}
+	if cfg.PostgresDSN != "" {
+		w, err := newPostgresWriter(cfg.PostgresDSN)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, w)
+	}
+	if cfg.RawDir != "" {
+		w, err := newRawWriter(cfg.RawDir, cfg.rawKeep())
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, w)
+	}
+	if cfg.MetricsFile != "" {
+		w, err := newMetricsFile(cfg.MetricsFile)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, w)
+	}
+	if cfg.InfluxURL != "" {
+		sinks = append(sinks, newInfluxWriter(cfg))
+	}
+	if cfg.Webhook != "" {
+		sinks = append(sinks, newWebhookWriter(cfg.Webhook))
+	}
+	if cfg.StatsDAddr != "" {
+		w, err := newStatsDWriter(cfg.StatsDAddr, cfg.StatsDPrefix)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, w)
+	}
+	return sinks, nil
+}
+
+// writeSinks passes result to every sink. Each sink is isolated from the
+// others: an error, or even a panic, in one is logged and the rest still
+// receive the result.
+func writeSinks(sinks []ResultSink, result *speedtest.FormattedSpeedTest) {
+	for _, sink := range sinks {
+		if err := writeSink(sink, result); err != nil {
+			slog.Error("Error writing result", "sink", sinkName(sink), "error", err)
+		}
+	}
+}
+
+func writeSink(sink ResultSink, result *speedtest.FormattedSpeedTest) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("sink panicked: %v", r)
+		}
+	}()
+	return sink.Write(result)
+}
+
+// sinkName names the sink type in logs, e.g. CSVWriter.
+func sinkName(sink ResultSink) string {
+	name := fmt.Sprintf("%T", sink)
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func closeSinks(sinks []ResultSink) {
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			slog.Error("Error closing output", "sink", sinkName(sink), "error", err)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"speedtest-cron/speedtest"
+)
+
+func testCSVOptions() csvOptions {
+	return csvOptions{unit: "mbps", comma: ',', precision: 2}
+}
+
+func testResult() *speedtest.FormattedSpeedTest {
+	return &speedtest.FormattedSpeedTest{
+		Timestamp:    "2024-06-01T10:00:00Z",
+		PingMs:       12.3,
+		DownloadMbps: 100,
+		UploadMbps:   20,
+		JitterMs:     1.5,
+		ServerName:   "Town",
+		ServerID:     "1234",
+		ISP:          "Acme",
+		Backend:      "ookla",
+		Label:        "home",
+		DurationMs:   15000,
+		RecordedAt:   "2024-06-01T10:00:01Z",
+	}
+}
+
+// readCSV returns all records of a CSV file written with testCSVOptions.
+func readCSV(t *testing.T, filename string) [][]string {
+	t.Helper()
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatalf("reading %s: %v", filename, err)
+	}
+	return records
+}
+
+func TestToCSV(t *testing.T) {
+	opts := testCSVOptions()
+	opts.tags = []string{"site"}
+	result := testResult()
+	result.Tags = map[string]string{"site": "attic"}
+
+	got := strings.Join(toCSV(result, opts), ",")
+	want := "2024-06-01T10:00:00Z,12.30,100.00,20.00,1.50,0.00,Town,1234,Acme,ookla,home,15000,2024-06-01T10:00:01Z,attic"
+	if got != want {
+		t.Errorf("toCSV() = %s, want %s", got, want)
+	}
+
+	result.LatencyOnly = true
+	row := toCSV(result, opts)
+	if row[2] != "" || row[3] != "" {
+		t.Errorf("toCSV() of a latency-only result has bandwidth %q/%q, want empty columns", row[2], row[3])
+	}
+}
+
+func TestCSVWriterWrite(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "results.csv")
+	opts := testCSVOptions()
+	w, err := newCSVWriter(filename, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(testResult()); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readCSV(t, filename)
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want a header and one row", len(records))
+	}
+	if strings.Join(records[0], ",") != strings.Join(csvHeader("mbps", nil), ",") {
+		t.Errorf("header = %v", records[0])
+	}
+	if strings.Join(records[1], ",") != strings.Join(toCSV(testResult(), opts), ",") {
+		t.Errorf("row = %v", records[1])
+	}
+}
+
+// diskFullWriter writes half of every write to w and then fails with ENOSPC,
+// like a device that fills up mid-row.
+type diskFullWriter struct {
+	w io.Writer
+}
+
+func (d diskFullWriter) Write(p []byte) (int, error) {
+	n, _ := d.w.Write(p[:len(p)/2])
+	return n, &os.PathError{Op: "write", Path: "results.csv", Err: syscall.ENOSPC}
+}
+
+func TestCSVWriterDiskFull(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "results.csv")
+	opts := testCSVOptions()
+	w, err := newCSVWriter(filename, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Write(testResult()); err != nil {
+		t.Fatal(err)
+	}
+
+	w.writer = opts.newWriter(diskFullWriter{w.file})
+	err = w.Write(testResult())
+	if err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Fatalf("Write() error = %v, want a disk full error", err)
+	}
+	if !w.diskFull {
+		t.Error("diskFull is not set after ENOSPC")
+	}
+	if records := readCSV(t, filename); len(records) != 2 {
+		t.Fatalf("got %d records after ENOSPC, want the partial row cut off", len(records))
+	}
+
+	// handleDiskFull replaced the sticky writer, so the next row goes through
+	if err := w.Write(testResult()); err != nil {
+		t.Fatalf("Write() after disk full error = %v", err)
+	}
+	if w.diskFull {
+		t.Error("diskFull is still set after a successful write")
+	}
+	if records := readCSV(t, filename); len(records) != 3 {
+		t.Errorf("got %d records, want a header and two rows", len(records))
+	}
+}
+
+func TestCSVWriterRotation(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "results.csv")
+	opts := testCSVOptions()
+	header := csvHeader(opts.unit, nil)
+	// Room for the header and two rows per file
+	opts.maxSize = opts.encodedSize(header) + 2*opts.encodedSize(toCSV(testResult(), opts))
+	w, err := newCSVWriter(filename, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const rows = 7
+	var wg sync.WaitGroup
+	for i := 0; i < rows; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.Write(testResult()); err != nil {
+				t.Errorf("Write() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "results*.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 {
+		t.Errorf("got %d files %v, want 4", len(files), files)
+	}
+	total := 0
+	for _, file := range files {
+		records := readCSV(t, file)
+		if len(records) == 0 || strings.Join(records[0], ",") != strings.Join(header, ",") {
+			t.Errorf("%s does not start with the header", file)
+			continue
+		}
+		for _, record := range records[1:] {
+			if record[0] == "timestamp" {
+				t.Errorf("%s has more than one header", file)
+			}
+		}
+		if len(records) > 3 {
+			t.Errorf("%s has %d rows, above -max-csv-size", file, len(records)-1)
+		}
+		total += len(records) - 1
+	}
+	if total != rows {
+		t.Errorf("got %d rows across all files, want %d", total, rows)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		v            float64
+		precision    int
+		decimalComma bool
+		want         string
+	}{
+		{93.456, 0, false, "93"},
+		{93.456, 2, false, "93.46"},
+		{93.456, 3, false, "93.456"},
+		{93.4, 3, false, "93.400"},
+		{93.456, 2, true, "93,46"},
+		{1234.5, 3, true, "1234,500"},
+		{93.456, 0, true, "93"},
+		{-12.345, 2, false, "-12.35"},
+		{-12.345, 2, true, "-12,35"},
+		// Exact halves round to even; 0.125 and 0.375 are exact in binary
+		{2.5, 0, false, "2"},
+		{3.5, 0, false, "4"},
+		{0.125, 2, false, "0.12"},
+		{0.375, 2, false, "0.38"},
+		// 1.005 is stored as slightly less than that
+		{1.005, 2, false, "1.00"},
+		{0, 0, false, "0"},
+		{0, 2, true, "0,00"},
+	}
+	for _, tt := range tests {
+		opts := csvOptions{precision: tt.precision, decimalComma: tt.decimalComma}
+		if got := opts.formatFloat(tt.v); got != tt.want {
+			t.Errorf("formatFloat(%v) with precision %d, decimal comma %v = %q, want %q", tt.v, tt.precision, tt.decimalComma, got, tt.want)
+		}
+	}
+}
+
+func TestToCSVLatencyOnlyZeros(t *testing.T) {
+	opts := csvOptions{unit: "mbps", comma: ';', precision: 0, decimalComma: true}
+	result := &speedtest.FormattedSpeedTest{PingMs: 10.5, LatencyOnly: true}
+	row := toCSV(result, opts)
+	// ping, download, upload, jitter and packet loss
+	got := strings.Join(row[1:6], ";")
+	if want := "10;;;0;0"; got != want {
+		t.Errorf("toCSV() measurements of a latency-only result = %q, want %q", got, want)
+	}
+}
+
+func TestConvertBandwidth(t *testing.T) {
+	tests := []struct {
+		mbps float64
+		unit string
+		want float64
+	}{
+		{100, "mbps", 100},
+		{100, "mbytes", 12.5},
+		{8, "mbytes", 1},
+		// 100 Mbps are 12,500,000 bytes/s
+		{100, "mibytes", 12_500_000.0 / (1 << 20)},
+		{8.388608, "mibytes", 1},
+		{0, "mibytes", 0},
+	}
+	for _, tt := range tests {
+		got := convertBandwidth(tt.mbps, tt.unit)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("convertBandwidth(%v, %q) = %v, want %v", tt.mbps, tt.unit, got, tt.want)
+		}
+		// readCSVResults converts back with the inverse of one unit
+		if back := got / convertBandwidth(1, tt.unit); math.Abs(back-tt.mbps) > 1e-9 {
+			t.Errorf("converting %v %s back gives %v Mbps, want %v", got, tt.unit, back, tt.mbps)
+		}
+	}
+}
+
+func TestCSVBandwidthRoundTrip(t *testing.T) {
+	for unit := range bandwidthUnits {
+		filename := filepath.Join(t.TempDir(), "results.csv")
+		opts := testCSVOptions()
+		opts.unit, opts.precision = unit, 6
+		w, err := newCSVWriter(filename, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write(testResult()); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+
+		results, err := readCSVResults(filename, ',', nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || math.Abs(results[0].DownloadMbps-100) > 1e-4 || math.Abs(results[0].UploadMbps-20) > 1e-4 {
+			t.Errorf("reading back %s gives %+v, want 100/20 Mbps", unit, results)
+		}
+	}
+}
+
+func TestDailyFilename(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database:", err)
+	}
+	day := time.Date(2024, 6, 1, 15, 4, 5, 0, time.UTC)
+	// Shortly before and after midnight in Berlin, both still June 1 in UTC
+	nearMidnight := time.Date(2024, 6, 1, 23, 30, 0, 0, berlin)
+	afterMidnight := time.Date(2024, 6, 2, 0, 30, 0, 0, berlin)
+	tests := []struct {
+		base string
+		t    time.Time
+		want string
+	}{
+		{"output.csv", day, "output-2024-06-01.csv"},
+		{"/data/results.csv", day, "/data/results-2024-06-01.csv"},
+		{"/data/results", day, "/data/results-2024-06-01"},
+		{"/data/results.csv.gz", day, "/data/results-2024-06-01.csv.gz"},
+		{"/data.d/results", day, "/data.d/results-2024-06-01"},
+		{"output.csv", nearMidnight, "output-2024-06-01.csv"},
+		// Named after the local date, although it is 22:30 on June 1 in UTC
+		{"output.csv", afterMidnight, "output-2024-06-02.csv"},
+	}
+	for _, tt := range tests {
+		if got := dailyFilename(tt.base, tt.t); got != tt.want {
+			t.Errorf("dailyFilename(%q, %v) = %q, want %q", tt.base, tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestJSONLStdoutCloseKeepsStdoutOpen(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	cfg := defaultConfig()
+	cfg.Output = stdoutPath
+	cfg.Format = "jsonl"
+	sinks, err := openSinks(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sink := range sinks {
+		if err := sink.Write(testResult()); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	closeSinks(sinks)
+	// A reload closes the sinks and opens new ones on the same stdout
+	if _, err := os.Stdout.WriteString("still open\n"); err != nil {
+		t.Fatalf("writing to stdout after Close() error = %v", err)
+	}
+	w.Close()
+
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"backend":"ookla"`) || lines[1] != "still open" {
+		t.Errorf("stdout = %q", out)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"speedtest-cron/speedtest"
+)
+
+// createSpeedtestsTable defines the speedtests table. The bandwidth columns
+// are NULL for latency-only results, recorded_at for rows written before it
+// was added, and tags holds the -tag values, or NULL without tags.
+const createSpeedtestsTable = `CREATE TABLE IF NOT EXISTS speedtests (
+	id            BIGSERIAL PRIMARY KEY,
+	timestamp     TIMESTAMPTZ NOT NULL,
+	ping_ms       DOUBLE PRECISION NOT NULL,
+	download_mbps DOUBLE PRECISION,
+	upload_mbps   DOUBLE PRECISION,
+	jitter_ms     DOUBLE PRECISION NOT NULL,
+	packet_loss   DOUBLE PRECISION NOT NULL,
+	server_name   TEXT NOT NULL,
+	server_id     TEXT NOT NULL,
+	isp           TEXT NOT NULL,
+	backend       TEXT NOT NULL,
+	label         TEXT NOT NULL,
+	duration_ms   BIGINT NOT NULL DEFAULT 0,
+	recorded_at   TIMESTAMPTZ,
+	tags          JSONB
+)`
+
+// migrateSpeedtestsTable updates a table created by an earlier version,
+// whose bandwidth columns were NOT NULL and which lacked the later columns.
+// Dropping a constraint that is already gone, or adding a column that
+// exists, is a no-op.
+const migrateSpeedtestsTable = `ALTER TABLE speedtests
+	ALTER COLUMN download_mbps DROP NOT NULL,
+	ALTER COLUMN upload_mbps DROP NOT NULL,
+	ADD COLUMN IF NOT EXISTS duration_ms BIGINT NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS recorded_at TIMESTAMPTZ,
+	ADD COLUMN IF NOT EXISTS tags JSONB`
+
+const insertSpeedtest = `INSERT INTO speedtests
+	(timestamp, ping_ms, download_mbps, upload_mbps, jitter_ms, packet_loss, server_name, server_id, isp, backend, label, duration_ms, recorded_at, tags)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`
+
+// PostgresWriter stores each result as a row in the speedtests table. The
+// server may be unreachable at startup or drop out later; the table and
+// statement are set up lazily so a failed write is simply retried with the
+// next result.
+type PostgresWriter struct {
+	db     *sql.DB
+	insert *sql.Stmt
+}
+
+func newPostgresWriter(dsn string) (*PostgresWriter, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening Postgres connection: %w", err)
+	}
+	db.SetMaxOpenConns(2)
+	db.SetConnMaxIdleTime(5 * time.Minute)
+	w := &PostgresWriter{db: db}
+	if err := w.prepare(); err != nil {
+		slog.Warn("Postgres is not reachable yet, retrying with the next result", "error", err)
+	}
+	return w, nil
+}
+
+// prepare creates or migrates the table and the insert statement if that
+// hasn't succeeded yet.
+func (w *PostgresWriter) prepare() error {
+	if w.insert != nil {
+		return nil
+	}
+	if _, err := w.db.Exec(createSpeedtestsTable); err != nil {
+		return fmt.Errorf("error creating speedtests table: %w", err)
+	}
+	if _, err := w.db.Exec(migrateSpeedtestsTable); err != nil {
+		return fmt.Errorf("error migrating speedtests table: %w", err)
+	}
+	insert, err := w.db.Prepare(insertSpeedtest)
+	if err != nil {
+		return fmt.Errorf("error preparing insert statement: %w", err)
+	}
+	w.insert = insert
+	return nil
+}
+
+func (w *PostgresWriter) Write(result *speedtest.FormattedSpeedTest) error {
+	if err := w.prepare(); err != nil {
+		return err
+	}
+	download, upload := bandwidthValues(result)
+	tags, err := tagsValue(result)
+	if err != nil {
+		return err
+	}
+	// TIMESTAMPTZ doesn't take an empty string
+	recordedAt := sql.NullString{String: result.RecordedAt, Valid: result.RecordedAt != ""}
+	_, err = w.insert.Exec(
+		result.Timestamp,
+		result.PingMs,
+		download,
+		upload,
+		result.JitterMs,
+		result.PacketLoss,
+		result.ServerName,
+		result.ServerID,
+		result.ISP,
+		result.Backend,
+		result.Label,
+		result.DurationMs,
+		recordedAt,
+		tags,
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting into Postgres: %w", err)
+	}
+	return nil
+}
+
+func (w *PostgresWriter) Close() error {
+	if w.insert != nil {
+		w.insert.Close()
+	}
+	return w.db.Close()
+}
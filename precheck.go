@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"speedtest-cron/speedtest"
+)
+
+// precheckTimeout bounds the startup connectivity check.
+const precheckTimeout = 10 * time.Second
+
+// precheckConnectivity pings host once before the first test, so a network
+// that is down at startup is reported as such rather than only as a failed
+// speed test. The outcome is only logged; testing goes ahead either way.
+func precheckConnectivity(ctx context.Context, host string, ipVersion int) {
+	args := []string{"-c", "1"}
+	if ipVersion != 0 {
+		args = append(args, "-"+strconv.Itoa(ipVersion))
+	}
+	_, _, err := speedtest.DefaultRunner.RunCommand(ctx, precheckTimeout, "ping", append(args, host), nil)
+	switch {
+	case err == nil:
+		slog.Info("Network reachable at startup", "host", host)
+	case errors.Is(err, speedtest.ErrBinaryNotFound):
+		slog.Warn("Skipping connectivity pre-check: ping is not installed; set -precheck-host to \"\" to disable it", "error", err)
+	case ctx.Err() == nil:
+		slog.Warn("No network at startup: pre-check host is unreachable; speed tests will likely fail until it is back and keep being retried", "host", host, "error", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"speedtest-cron/speedtest"
+)
+
+// rawFilePrefix starts the name of every file RawWriter writes, so pruning
+// never touches anything else in the directory.
+const rawFilePrefix = "speedtest-"
+
+// RawWriter saves the raw CLI output of each result to its own file in dir,
+// named after the time and backend, and prunes old files according to keep.
+type RawWriter struct {
+	dir  string
+	keep keepPolicy
+}
+
+func newRawWriter(dir string, keep keepPolicy) (*RawWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating raw output directory: %w", err)
+	}
+	return &RawWriter{dir: dir, keep: keep}, nil
+}
+
+func (w *RawWriter) Write(result *speedtest.FormattedSpeedTest) error {
+	if result.Raw == nil {
+		return nil
+	}
+	ext := ".json"
+	if result.Backend == "ping" {
+		ext = ".txt"
+	}
+	name := rawFilePrefix + time.Now().UTC().Format("20060102T150405.000Z") + "-" + result.Backend + ext
+	if err := os.WriteFile(filepath.Join(w.dir, name), result.Raw, 0644); err != nil {
+		return fmt.Errorf("error writing raw output: %w", err)
+	}
+	if err := w.prune(); err != nil {
+		slog.Error("Error pruning raw output", "dir", w.dir, "error", err)
+	}
+	return nil
+}
+
+// prune removes the raw files that keep no longer allows. The timestamp in
+// the names makes them sort oldest first.
+func (w *RawWriter) prune() error {
+	if w.keep == (keepPolicy{}) {
+		return nil
+	}
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasPrefix(entry.Name(), rawFilePrefix) {
+			paths = append(paths, filepath.Join(w.dir, entry.Name()))
+		}
+	}
+	slices.Sort(paths)
+
+	for _, path := range w.keep.expired(paths) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *RawWriter) Close() error {
+	return nil
+}
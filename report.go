@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"speedtest-cron/speedtest"
+)
+
+// runStats prints count, min, max, mean and percentiles of every metric in
+// the CSV output, limited to the last -since if set, and returns the exit
+// code.
+func runStats(cfg *Config, out io.Writer) int {
+	now := time.Now()
+	var start time.Time
+	if cfg.Since > 0 {
+		start = now.Add(-cfg.Since)
+	}
+	results, err := readStatsResults(cfg, start, now)
+	if err != nil {
+		fmt.Fprintf(out, "Error reading results: %v\n", err)
+		return 1
+	}
+
+	var download, upload, ping, jitter, packetLoss metricSummary
+	count := 0
+	for _, result := range results {
+		t, err := recordedTime(result)
+		if err != nil || t.Before(start) {
+			continue
+		}
+		count++
+		if !result.LatencyOnly {
+			download.add(result.DownloadMbps)
+			upload.add(result.UploadMbps)
+		}
+		ping.add(result.PingMs)
+		jitter.add(result.JitterMs)
+		packetLoss.add(result.PacketLoss)
+	}
+
+	if start.IsZero() {
+		fmt.Fprintf(out, "%d result(s) in total\n", count)
+	} else {
+		fmt.Fprintf(out, "%d result(s) since %s\n", count, start.Format(time.RFC3339))
+	}
+	if count == 0 {
+		return 0
+	}
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "metric\tcount\tmin\tmax\tavg\tp50\tp95\t")
+	for _, metric := range []struct {
+		name    string
+		summary *metricSummary
+	}{
+		{"download_mbps", &download},
+		{"upload_mbps", &upload},
+		{"ping_ms", &ping},
+		{"jitter_ms", &jitter},
+		{"packet_loss", &packetLoss},
+	} {
+		s := metric.summary
+		if s.count == 0 {
+			fmt.Fprintf(tw, "%s\t0\t-\t-\t-\t-\t-\t\n", metric.name)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t\n", metric.name, s.count,
+			s.min, s.max, s.avg(), s.samples.Percentile(50), s.samples.Percentile(95))
+	}
+	tw.Flush()
+	return 0
+}
+
+// recordedTime returns when result was recorded by the host's clock, which
+// is more trustworthy than the CLI's, falling back to the CLI's timestamp for
+// rows written before recorded_at existed.
+func recordedTime(result *speedtest.FormattedSpeedTest) (time.Time, error) {
+	recorded := result.RecordedAt
+	if recorded == "" {
+		recorded = result.Timestamp
+	}
+	return time.Parse(time.RFC3339, recorded)
+}
+
+// readStatsResults reads the CSV results that may fall between start and
+// end. With daily rotation that is the file of each day in between, or just
+// today's without a start, and in either case the files rotated by size at
+// or after start. Finding no file at all is an fs.ErrNotExist.
+func readStatsResults(cfg *Config, start, end time.Time) ([]*speedtest.FormattedSpeedTest, error) {
+	var header []string
+	if cfg.NoHeader {
+		header = csvHeader(cfg.Units, cfg.Tags.keys())
+	}
+	files := []string{cfg.csvPath()}
+	if cfg.Rotate == "daily" {
+		files = nil
+		day := end
+		if !start.IsZero() {
+			day = start
+		}
+		for ; !day.After(end); day = day.AddDate(0, 0, 1) {
+			files = append(files, dailyFilename(cfg.csvPath(), day))
+		}
+		if last := dailyFilename(cfg.csvPath(), end); files[len(files)-1] != last {
+			files = append(files, last)
+		}
+	}
+	rotated, err := rotatedCSVsSince(cfg.csvPath(), start)
+	if err != nil {
+		return nil, err
+	}
+	files = append(rotated, files...)
+
+	var results []*speedtest.FormattedSpeedTest
+	found := false
+	for _, file := range files {
+		rows, err := readCSVResults(file, cfg.csvComma(), header, 0)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		found = true
+		results = append(results, rows...)
+	}
+	if !found {
+		return nil, fmt.Errorf("no CSV output found at %s: %w", cfg.csvPath(), fs.ErrNotExist)
+	}
+	return results, nil
+}
+
+// rotationStamp matches the time rotatedFilename puts into a file name.
+var rotationStamp = regexp.MustCompile(`\d{8}T\d{6}`)
+
+// rotatedCSVsSince returns the CSV files next to base that were rotated by
+// size, or moved aside by -force, at or after start, oldest first. Rows are
+// only ever written before a rotation, so earlier files can't hold results
+// from start on. Daily files that were never rotated are left to the caller.
+func rotatedCSVsSince(base string, start time.Time) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(base))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error listing CSV directory: %w", err)
+	}
+	type file struct {
+		path    string
+		rotated time.Time
+	}
+	var files []file
+	pattern := rotatedCSVPattern(base)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !pattern.MatchString(entry.Name()) {
+			continue
+		}
+		stamp := rotationStamp.FindString(entry.Name())
+		if stamp == "" {
+			continue
+		}
+		// rotatedFilename uses the local time, to the second
+		rotated, err := time.ParseInLocation("20060102T150405", stamp, time.Local)
+		if err != nil || rotated.Before(start.Truncate(time.Second)) {
+			continue
+		}
+		files = append(files, file{path: filepath.Join(filepath.Dir(base), entry.Name()), rotated: rotated})
+	}
+	slices.SortFunc(files, func(a, b file) int {
+		if c := a.rotated.Compare(b.rotated); c != 0 {
+			return c
+		}
+		// Within a second, the names differ by the -N suffix: none, -1, -2, ...
+		if c := len(a.path) - len(b.path); c != 0 {
+			return c
+		}
+		return strings.Compare(a.path, b.path)
+	})
+
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// keepPolicy limits how many old files are kept, by count or by age. The
+// zero value keeps everything.
+type keepPolicy struct {
+	count int
+	age   time.Duration
+}
+
+// parseKeepPolicy parses -raw-keep and -retention: a plain number is a file
+// count, anything else a maximum age such as 168h.
+func parseKeepPolicy(s string) (keepPolicy, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 1 {
+			return keepPolicy{}, fmt.Errorf("file count must be at least 1")
+		}
+		return keepPolicy{count: n}, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return keepPolicy{}, fmt.Errorf("must be a file count such as 100 or an age such as 168h")
+	}
+	return keepPolicy{age: d}, nil
+}
+
+// expired returns the paths, sorted oldest first, that are beyond the count
+// limit or older than the age limit.
+func (k keepPolicy) expired(paths []string) []string {
+	var expired []string
+	if k.count > 0 && len(paths) > k.count {
+		expired = paths[:len(paths)-k.count]
+	}
+	if k.age > 0 {
+		cutoff := time.Now().Add(-k.age)
+		for _, path := range paths[len(expired):] {
+			info, err := os.Stat(path)
+			if err == nil && info.ModTime().Before(cutoff) {
+				expired = append(expired, path)
+			}
+		}
+	}
+	return expired
+}
+
+// rotatedCSVPattern matches the names CSV output based on base is rotated
+// or switched to: daily files (output-2024-06-01.csv), size rotations
+// (output-20240601T150405.csv, optionally with -N) of either, and files
+// moved aside by -force.
+func rotatedCSVPattern(base string) *regexp.Regexp {
+	stem, ext := splitExt(filepath.Base(base))
+	rotation := `\d{8}T\d{6}(-\d+)?`
+	return regexp.MustCompile(`^` + regexp.QuoteMeta(stem) + `-(\d{4}-\d{2}-\d{2}(-` + rotation + `)?|` + rotation + `)` + regexp.QuoteMeta(ext) + `$`)
+}
+
+// pruneRotatedCSVs deletes the rotated CSV files next to base that keep no
+// longer allows, oldest by modification time first. current, the file
+// being written, is never touched, and neither is anything not named like a
+// rotated file.
+func pruneRotatedCSVs(base, current string, keep keepPolicy) error {
+	if keep == (keepPolicy{}) {
+		return nil
+	}
+	dir := filepath.Dir(base)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("error listing CSV directory: %w", err)
+	}
+	pattern := rotatedCSVPattern(base)
+	type file struct {
+		path    string
+		modTime time.Time
+	}
+	var files []file
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if !entry.Type().IsRegular() || !pattern.MatchString(entry.Name()) || path == filepath.Join(dir, filepath.Base(current)) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files = append(files, file{path: path, modTime: info.ModTime()})
+		}
+	}
+	slices.SortFunc(files, func(a, b file) int { return a.modTime.Compare(b.modTime) })
+
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	for _, path := range keep.expired(paths) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error pruning old CSV file: %w", err)
+		}
+		slog.Info("Pruned old CSV file", "file", path)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// scheduler delivers a tick each time a test is due.
+type scheduler interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// newScheduler returns a cron-driven scheduler when a cron expression is
+// configured and a fixed-interval ticker otherwise, optionally delaying each
+// tick by a random jitter.
+func newScheduler(cfg *Config) (scheduler, error) {
+	var s scheduler
+	if cfg.Cron != "" {
+		cs, err := newCronScheduler(cfg.Cron)
+		if err != nil {
+			return nil, err
+		}
+		s = cs
+	} else {
+		s = &tickerScheduler{ticker: time.NewTicker(cfg.Interval)}
+	}
+	if cfg.Jitter > 0 {
+		s = newJitterScheduler(s, cfg.Jitter)
+	}
+	return s, nil
+}
+
+type tickerScheduler struct {
+	ticker *time.Ticker
+}
+
+func (s *tickerScheduler) C() <-chan time.Time { return s.ticker.C }
+func (s *tickerScheduler) Stop()               { s.ticker.Stop() }
+
+type cronScheduler struct {
+	cron *cron.Cron
+	c    chan time.Time
+}
+
+func newCronScheduler(spec string) (*cronScheduler, error) {
+	s := &cronScheduler{
+		cron: cron.New(),
+		c:    make(chan time.Time, 1),
+	}
+	_, err := s.cron.AddFunc(spec, func() {
+		// Like time.Ticker, drop the tick if the previous one hasn't been
+		// picked up yet because a test is still running.
+		select {
+		case s.c <- time.Now():
+		default:
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %w", spec, err)
+	}
+	s.cron.Start()
+	return s, nil
+}
+
+func (s *cronScheduler) C() <-chan time.Time { return s.c }
+
+// Stop prevents further ticks and waits for a pending tick to be delivered.
+func (s *cronScheduler) Stop() {
+	<-s.cron.Stop().Done()
+}
+
+// jitterScheduler delays every tick of the wrapped scheduler by a random
+// offset in [0, jitter), drawn anew for each tick. Because the offset is
+// measured from the underlying schedule rather than the previous run, the
+// spacing between runs varies but still averages out to the configured
+// interval.
+type jitterScheduler struct {
+	inner  scheduler
+	jitter time.Duration
+	c      chan time.Time
+	done   chan struct{}
+}
+
+func newJitterScheduler(inner scheduler, jitter time.Duration) *jitterScheduler {
+	s := &jitterScheduler{
+		inner:  inner,
+		jitter: jitter,
+		c:      make(chan time.Time, 1),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *jitterScheduler) run() {
+	for {
+		select {
+		case <-s.inner.C():
+		case <-s.done:
+			return
+		}
+
+		timer := time.NewTimer(time.Duration(rand.Int63n(int64(s.jitter))))
+		select {
+		case t := <-timer.C:
+			select {
+			case s.c <- t:
+			default:
+			}
+		case <-s.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (s *jitterScheduler) C() <-chan time.Time { return s.c }
+
+func (s *jitterScheduler) Stop() {
+	close(s.done)
+	s.inner.Stop()
+}
+
+// longestCronGap returns the longest time between two consecutive
+// activations of spec in the week after now. Schedules such as
+// "0 9,18 * * 1-5" space their runs unevenly, and a health check must allow
+// for the weekend gap rather than the nine hours between 9:00 and 18:00.
+func longestCronGap(spec string, now time.Time) (time.Duration, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cron expression %q: %w", spec, err)
+	}
+	first := schedule.Next(now)
+	if first.IsZero() {
+		return 0, fmt.Errorf("cron expression %q never runs", spec)
+	}
+	var longest time.Duration
+	// Always look at one gap, even for schedules running less than weekly
+	for prev, next := first, schedule.Next(first); !next.IsZero(); prev, next = next, schedule.Next(next) {
+		if gap := next.Sub(prev); gap > longest {
+			longest = gap
+		}
+		if next.Sub(first) >= 7*24*time.Hour {
+			break
+		}
+	}
+	return longest, nil
+}
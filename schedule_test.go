@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLongestCronGap(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2024, 6, 5, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Duration
+	}{
+		{"*/15 * * * *", 15 * time.Minute},
+		{"0 * * * *", time.Hour},
+		{"0 9,18 * * *", 15 * time.Hour},
+		// Friday 18:00 to Monday 9:00
+		{"0 9,18 * * 1-5", 63 * time.Hour},
+		{"0 3 * * 0", 7 * 24 * time.Hour},
+		// July 1 to August 1
+		{"0 0 1 * *", 31 * 24 * time.Hour},
+	}
+	for _, tt := range tests {
+		got, err := longestCronGap(tt.spec, now)
+		if err != nil {
+			t.Errorf("longestCronGap(%q) error = %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("longestCronGap(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+
+	if _, err := longestCronGap("not a schedule", now); err == nil {
+		t.Error("longestCronGap() of an invalid spec succeeded")
+	}
+}
+
+func TestHealthWindow(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Jitter = 5 * time.Minute
+	if got := healthWindow(&cfg); got != 35*time.Minute {
+		t.Errorf("healthWindow() with -interval = %v, want 35m", got)
+	}
+	cfg.Cron = "*/10 * * * *"
+	if got := healthWindow(&cfg); got != 15*time.Minute {
+		t.Errorf("healthWindow() with -cron = %v, want 15m", got)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// slackColors maps each notification kind to the attachment color shown
+// beside the message.
+var slackColors = map[NotificationKind]string{
+	NotifyFailure:   "danger",
+	NotifyBreach:    "warning",
+	NotifyRecovery:  "good",
+	NotifyISPChange: "warning",
+}
+
+// SlackNotifier posts notifications to a Slack incoming webhook.
+type SlackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newSlackNotifier(url string) *SlackNotifier {
+	return &SlackNotifier{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// slackPayload formats n as a single color-coded attachment.
+func slackPayload(n Notification) ([]byte, error) {
+	type attachment struct {
+		Color    string `json:"color"`
+		Title    string `json:"title"`
+		Text     string `json:"text"`
+		Fallback string `json:"fallback"`
+	}
+	return json.Marshal(struct {
+		Attachments []attachment `json:"attachments"`
+	}{
+		Attachments: []attachment{{
+			Color:    slackColors[n.Kind],
+			Title:    n.Subject,
+			Text:     n.Message,
+			Fallback: n.Subject,
+		}},
+	})
+}
+
+func (s *SlackNotifier) Notify(n Notification) error {
+	body, err := slackPayload(n)
+	if err != nil {
+		return fmt.Errorf("error encoding Slack message: %w", err)
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error posting to Slack: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Slack responded with %s", resp.Status)
+	}
+	return nil
+}
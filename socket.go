@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"speedtest-cron/speedtest"
+)
+
+// SocketServer writes the most recent result as a line of JSON to every
+// client that connects to a Unix domain socket, then closes the connection.
+// Before the first result it writes null. It implements ResultSink like
+// StatusServer, and closing it removes the socket file.
+type SocketServer struct {
+	path     string
+	listener net.Listener
+
+	mu     sync.RWMutex
+	latest *speedtest.FormattedSpeedTest
+}
+
+func newSocketServer(path string) (*SocketServer, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	if err := ensureDir(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on socket: %w", err)
+	}
+	return &SocketServer{path: path, listener: listener}, nil
+}
+
+// removeStaleSocket deletes a socket file left behind by a process that
+// didn't shut down cleanly. A socket something still answers on, or a file
+// that isn't a socket, is left alone.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error checking socket path: %w", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use by another process", path)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("error removing stale socket: %w", err)
+	}
+	slog.Info("Removed stale socket", "path", path)
+	return nil
+}
+
+// Start accepts connections in the background until Close is called.
+func (s *SocketServer) Start() {
+	slog.Info("Serving results on Unix socket", "path", s.path)
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if err != nil {
+				slog.Error("Socket accept error", "error", err)
+				continue
+			}
+			go s.serve(conn)
+		}
+	}()
+}
+
+func (s *SocketServer) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	s.mu.RLock()
+	latest := s.latest
+	s.mu.RUnlock()
+	if err := json.NewEncoder(conn).Encode(latest); err != nil {
+		slog.Debug("Error writing to socket client", "error", err)
+	}
+}
+
+func (s *SocketServer) Write(result *speedtest.FormattedSpeedTest) error {
+	s.mu.Lock()
+	s.latest = result
+	s.mu.Unlock()
+	return nil
+}
+
+// Close stops accepting connections; closing a Unix listener also removes
+// the socket file.
+func (s *SocketServer) Close() error {
+	return s.listener.Close()
+}
@@ -0,0 +1,226 @@
+package speedtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FindBinary resolves a speed test executable, explaining where it
+// looked when it can't be found. flagName is the flag that overrides it.
+func FindBinary(binary, flagName string) (string, error) {
+	path, err := exec.LookPath(binary)
+	if err == nil {
+		return path, nil
+	}
+	searched := binary
+	if !strings.ContainsRune(binary, filepath.Separator) {
+		searched = strings.Join(filepath.SplitList(os.Getenv("PATH")), ", ")
+	}
+	if flagName == "" {
+		return "", fmt.Errorf("%q not found (searched: %s); install it", binary, searched)
+	}
+	return "", fmt.Errorf("speed test binary %q not found (searched: %s); install it or point -%s at it", binary, searched, flagName)
+}
+
+// ErrBinaryNotFound is wrapped by the error of a test whose CLI couldn't be
+// found, for example because it lives on a mount that is unavailable. The
+// test isn't retried, but the binary may well be back for the next one.
+var ErrBinaryNotFound = errors.New("speed test binary not found")
+
+// maxOutputBytes bounds the stdout kept from one CLI run; a CLI printing more
+// is misbehaving and its run fails. maxStderrBytes is how much of the end of
+// stderr is kept for error messages.
+const (
+	maxOutputBytes = 16 << 20
+	maxStderrBytes = 64 << 10
+)
+
+// errOutputTooLarge is returned by boundedBuffer once maxOutputBytes is
+// exceeded, which also stops reading from the CLI.
+var errOutputTooLarge = errors.New("output too large")
+
+// CommandRunner runs the CLI behind a tester. Testers use DefaultRunner
+// unless their Runner field is set, so tests can substitute a fake that
+// returns canned output or errors without the CLI or a network.
+type CommandRunner interface {
+	RunCommand(ctx context.Context, timeout time.Duration, binary string, args []string, onLine func([]byte)) (stdout, stderr []byte, err error)
+}
+
+// CommandRunnerFunc adapts a function to the CommandRunner interface.
+type CommandRunnerFunc func(ctx context.Context, timeout time.Duration, binary string, args []string, onLine func([]byte)) (stdout, stderr []byte, err error)
+
+func (f CommandRunnerFunc) RunCommand(ctx context.Context, timeout time.Duration, binary string, args []string, onLine func([]byte)) (stdout, stderr []byte, err error) {
+	return f(ctx, timeout, binary, args, onLine)
+}
+
+// DefaultRunner executes the CLI as a child process.
+var DefaultRunner CommandRunner = CommandRunnerFunc(runCommand)
+
+// runnerOrDefault returns r, or DefaultRunner if r is nil.
+func runnerOrDefault(r CommandRunner) CommandRunner {
+	if r == nil {
+		return DefaultRunner
+	}
+	return r
+}
+
+// runCommand runs a speed test CLI, killing it once timeout passes, and
+// returns its stdout and stderr separately so warnings on stderr can't break
+// parsing. If onLine is set, it is also called with each line of stdout as
+// soon as the CLI prints it. A non-zero exit is reported as an error wrapping
+// *exec.ExitError, with stderr included in the message. Memory stays bounded:
+// a run printing more than maxOutputBytes to stdout fails, and only the last
+// maxStderrBytes of stderr are kept.
+func runCommand(ctx context.Context, timeout time.Duration, binary string, args []string, onLine func([]byte)) (stdout, stderr []byte, err error) {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	testCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(testCtx, binary, args...)
+	// Ask the CLI to stop on cancellation and only kill it if it lingers
+	cmd.Cancel = func() error { return cmd.Process.Signal(syscall.SIGTERM) }
+	cmd.WaitDelay = 5 * time.Second
+	errBuf := &tailBuffer{max: maxStderrBytes}
+	cmd.Stderr = errBuf
+	out := &boundedBuffer{max: maxOutputBytes}
+	if onLine == nil {
+		cmd.Stdout = out
+	} else {
+		cmd.Stdout = &lineWriter{output: out, onLine: onLine}
+	}
+	err = cmd.Run()
+	stdout, stderr = out.buf.Bytes(), errBuf.buf
+	if ctx.Err() != nil {
+		return stdout, stderr, fmt.Errorf("%s cancelled: %w", binary, ctx.Err())
+	}
+	if testCtx.Err() == context.DeadlineExceeded {
+		return stdout, stderr, fmt.Errorf("%s did not finish within %v and was killed: %w", binary, timeout, context.DeadlineExceeded)
+	}
+	if out.exceeded {
+		// The CLI was cut off, so whatever error it exited with is a symptom
+		return stdout, stderr, fmt.Errorf("%s printed more than %d bytes and was stopped: %w", binary, maxOutputBytes, errOutputTooLarge)
+	}
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return stdout, stderr, permanent(fmt.Errorf("error starting %s: %w: %w", binary, ErrBinaryNotFound, err))
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return stdout, stderr, permanent(fmt.Errorf("error starting %s: %w", binary, err))
+	}
+	if err != nil {
+		// Some CLIs report errors on stdout; show that if stderr is empty
+		detail := stderr
+		if len(bytes.TrimSpace(detail)) == 0 {
+			detail = stdout
+		}
+		return stdout, stderr, fmt.Errorf("error running %s: %w\nOutput: %s", binary, err, string(detail))
+	}
+	return stdout, stderr, nil
+}
+
+// exitedWithError reports whether err is runCommand's error for a CLI that
+// ran to completion but exited non-zero.
+func exitedWithError(err error) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr)
+}
+
+// parseDespiteExit parses the output of a CLI that exited non-zero. Some
+// CLIs exit with an error after printing a complete result, for example
+// over a harmless warning; such a result is kept. Otherwise err is returned.
+func parseDespiteExit(binary string, stdout []byte, err error, parse func([]byte) (*FormattedSpeedTest, error)) (*FormattedSpeedTest, error) {
+	if !exitedWithError(err) {
+		return nil, err
+	}
+	result, parseErr := parse(stdout)
+	if parseErr != nil {
+		return nil, err
+	}
+	slog.Warn("Speed test CLI exited with an error but printed a valid result; keeping it", "binary", binary, "error", err)
+	return result, nil
+}
+
+// permanentError marks a failure that retrying can't fix, such as a missing
+// binary or an unaccepted license, so RunWithRetry gives up at once.
+// Everything else, like timeouts or being offline, is retried.
+type permanentError struct {
+	err error
+}
+
+func permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// isPermanent reports whether err, or an error it wraps, is permanent.
+func isPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}
+
+// boundedBuffer collects up to max bytes of output and fails writes beyond
+// that.
+type boundedBuffer struct {
+	buf      bytes.Buffer
+	max      int
+	exceeded bool
+}
+
+func (b *boundedBuffer) Write(p []byte) (int, error) {
+	if b.buf.Len()+len(p) > b.max {
+		b.exceeded = true
+		return 0, errOutputTooLarge
+	}
+	return b.buf.Write(p)
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	buf []byte
+	max int
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.max:]...)
+	}
+	return len(p), nil
+}
+
+// lineWriter collects command output and hands every complete line to
+// onLine as it arrives.
+type lineWriter struct {
+	output  *boundedBuffer
+	partial []byte
+	onLine  func([]byte)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	if _, err := w.output.Write(p); err != nil {
+		return 0, err
+	}
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.onLine(bytes.TrimSpace(w.partial[:i]))
+		w.partial = w.partial[i+1:]
+	}
+	return len(p), nil
+}
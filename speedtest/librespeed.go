@@ -0,0 +1,160 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// librespeedResult is one entry of the array printed by librespeed-cli --json.
+// Unlike the Ookla CLI, speeds are already reported in Mbps.
+type librespeedResult struct {
+	Timestamp time.Time `json:"timestamp"`
+	Server    struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"server"`
+	Client struct {
+		IP  string `json:"ip"`
+		Org string `json:"org"`
+	} `json:"client"`
+	Ping     float64 `json:"ping"`
+	Jitter   float64 `json:"jitter"`
+	Upload   float64 `json:"upload"`
+	Download float64 `json:"download"`
+}
+
+// LibrespeedTester runs librespeed-cli.
+type LibrespeedTester struct {
+	// Binary is the name or path of the librespeed-cli executable.
+	Binary string
+	// Timeout bounds a single run of the CLI.
+	Timeout time.Duration
+	// Interface and SourceIP bind the test to one network interface or local
+	// address on multi-homed hosts.
+	Interface string
+	SourceIP  string
+	// IPVersion forces IPv4 or IPv6 when set to 4 or 6.
+	IPVersion int
+	// LatencyOnly skips the download and upload phases.
+	LatencyOnly bool
+	// Server picks the server instead of the public list: a numeric ID is
+	// passed as --server, an http(s) URL of a server list as --server-json
+	// and anything else as --local-json, the path of a server list file such
+	// as one describing a self-hosted server.
+	Server string
+	// MaxPlausibleMbps rejects results with more bandwidth as invalid, so
+	// they are retried; zero disables the check.
+	MaxPlausibleMbps float64
+	// Runner runs the CLI; nil means DefaultRunner.
+	Runner CommandRunner
+}
+
+func (t *LibrespeedTester) args() []string {
+	args := []string{"--json"}
+	if t.Interface != "" {
+		args = append(args, "--interface", t.Interface)
+	}
+	if t.SourceIP != "" {
+		args = append(args, "--source", t.SourceIP)
+	}
+	if t.IPVersion != 0 {
+		args = append(args, "--ipv"+strconv.Itoa(t.IPVersion))
+	}
+	if t.Server != "" {
+		args = append(args, librespeedServerArgs(t.Server)...)
+	}
+	if t.LatencyOnly {
+		args = append(args, "--no-download", "--no-upload")
+	}
+	return args
+}
+
+// librespeedServerArgs returns the librespeed-cli arguments selecting server,
+// see LibrespeedTester.Server.
+func librespeedServerArgs(server string) []string {
+	if _, err := strconv.Atoi(server); err == nil {
+		return []string{"--server", server}
+	}
+	if strings.HasPrefix(server, "http://") || strings.HasPrefix(server, "https://") {
+		return []string{"--server-json", server}
+	}
+	return []string{"--local-json", server}
+}
+
+func (t *LibrespeedTester) Name() string { return "librespeed" }
+
+// Run runs librespeed-cli once and parses its JSON result.
+func (t *LibrespeedTester) Run(ctx context.Context) (*FormattedSpeedTest, error) {
+	output, _, err := runnerOrDefault(t.Runner).RunCommand(ctx, t.Timeout, t.Binary, t.args(), nil)
+	parse := func(output []byte) (*FormattedSpeedTest, error) {
+		return parseLibrespeedOutput(output, t.LatencyOnly, t.MaxPlausibleMbps)
+	}
+	var result *FormattedSpeedTest
+	if err != nil {
+		result, err = parseDespiteExit(t.Binary, output, err, parse)
+	} else {
+		result, err = parse(output)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.LatencyOnly = t.LatencyOnly
+	result.Raw = output
+	return result, nil
+}
+
+// parseLibrespeedOutput parses librespeed-cli's JSON output and rejects
+// results that can't be real measurements, see validateLibrespeed.
+func parseLibrespeedOutput(output []byte, latencyOnly bool, maxMbps float64) (*FormattedSpeedTest, error) {
+	// Skip anything printed before the JSON array
+	start := strings.IndexByte(string(output), '[')
+	if start < 0 {
+		return nil, fmt.Errorf("no valid librespeed result found in output")
+	}
+
+	var results []librespeedResult
+	if err := json.Unmarshal(output[start:], &results); err != nil {
+		return nil, fmt.Errorf("error parsing librespeed output: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no valid librespeed result found in output")
+	}
+
+	result := results[0]
+	if err := validateLibrespeed(result, latencyOnly, maxMbps); err != nil {
+		return nil, fmt.Errorf("invalid speed test result: %w", err)
+	}
+	return &FormattedSpeedTest{
+		Timestamp:    result.Timestamp.Format(time.RFC3339),
+		PingMs:       result.Ping,
+		DownloadMbps: result.Download,
+		UploadMbps:   result.Upload,
+		JitterMs:     result.Jitter,
+		ServerName:   result.Server.Name,
+		// librespeed servers have no stable numeric ID; the URL identifies them
+		ServerID: result.Server.URL,
+		ISP:      result.Client.Org,
+	}, nil
+}
+
+// validateLibrespeed applies the rules of validate to a librespeed-cli
+// result: zero bandwidth, a non-positive latency or bandwidth above maxMbps
+// is an error. Latency-only runs don't measure bandwidth, so only their ping
+// is checked.
+func validateLibrespeed(result librespeedResult, latencyOnly bool, maxMbps float64) error {
+	switch {
+	case result.Ping <= 0:
+		return fmt.Errorf("ping latency is %v ms", result.Ping)
+	case latencyOnly:
+		return nil
+	case result.Download <= 0:
+		return fmt.Errorf("download bandwidth is %v Mbps", result.Download)
+	case result.Upload <= 0:
+		return fmt.Errorf("upload bandwidth is %v Mbps", result.Upload)
+	}
+	return checkPlausible(result.Download, result.Upload, maxMbps)
+}
@@ -0,0 +1,65 @@
+package speedtest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLibrespeedOutput(t *testing.T) {
+	tests := []struct {
+		name        string
+		output      string
+		latencyOnly bool
+		maxMbps     float64
+		// wantErr is empty when the result is valid.
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			output: "Retrieving server list\n" + `[{"ping":8.5,"jitter":0.7,"upload":30.1,"download":150.2}]`,
+		},
+		{
+			name:    "zero download",
+			output:  `[{"ping":8.5,"upload":30.1,"download":0}]`,
+			wantErr: "download bandwidth is 0",
+		},
+		{
+			name:    "zero upload",
+			output:  `[{"ping":8.5,"upload":0,"download":150.2}]`,
+			wantErr: "upload bandwidth is 0",
+		},
+		{
+			name:    "zero ping",
+			output:  `[{"ping":0,"upload":30.1,"download":150.2}]`,
+			wantErr: "ping latency is 0",
+		},
+		{
+			name:    "implausible",
+			output:  `[{"ping":8.5,"upload":30.1,"download":150.2}]`,
+			maxMbps: 100,
+			wantErr: "above the plausible maximum",
+		},
+		{
+			name:        "latency only",
+			output:      `[{"ping":8.5,"upload":0,"download":0}]`,
+			latencyOnly: true,
+		},
+		{
+			name:        "latency only without ping",
+			output:      `[{"ping":0,"upload":0,"download":0}]`,
+			latencyOnly: true,
+			wantErr:     "ping latency is 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseLibrespeedOutput([]byte(tt.output), tt.latencyOnly, tt.maxMbps)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("parseLibrespeedOutput() error = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("parseLibrespeedOutput() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package speedtest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OoklaTester runs the official Ookla speedtest CLI.
+type OoklaTester struct {
+	// Binary is the name or path of the speedtest executable.
+	Binary string
+	// AcceptLicense passes --accept-license and --accept-gdpr so fresh
+	// installs don't block on the interactive prompt.
+	AcceptLicense bool
+	// Timeout bounds a single run of the CLI.
+	Timeout time.Duration
+	// ServerIDs pins each test to one of these servers in turn; empty means
+	// automatic selection. next is the index of the server used next.
+	ServerIDs []string
+	next      int
+	// ServerFallback retries with automatic selection when a pinned server
+	// fails.
+	ServerFallback bool
+	// Interface and SourceIP bind the test to one network interface or local
+	// address on multi-homed hosts.
+	Interface string
+	SourceIP  string
+	// IPVersion forces IPv4 or IPv6 when set to 4 or 6.
+	IPVersion int
+	// Progress streams the CLI's progress updates to the log while the test
+	// runs.
+	Progress bool
+	// ExtraArgs are appended verbatim after the built-in arguments.
+	ExtraArgs []string
+	// Fields locates ping and bandwidth in the CLI's JSON; the zero value
+	// matches the official CLI.
+	Fields FieldMap
+	// MaxPlausibleMbps rejects results with more bandwidth as invalid, so
+	// they are retried; zero disables the check.
+	MaxPlausibleMbps float64
+	// Runner runs the CLI; nil means DefaultRunner.
+	Runner CommandRunner
+}
+
+func (t *OoklaTester) args(serverID string) []string {
+	args := []string{"--format=json"}
+	if !t.Progress {
+		args = append(args, "--progress=no")
+	}
+	if t.AcceptLicense {
+		args = append(args, "--accept-license", "--accept-gdpr")
+	}
+	if serverID != "" {
+		args = append(args, "--server-id="+serverID)
+	}
+	if t.Interface != "" {
+		args = append(args, "--interface="+t.Interface)
+	}
+	if t.SourceIP != "" {
+		args = append(args, "--ip="+t.SourceIP)
+	}
+	if t.IPVersion != 0 {
+		args = append(args, "-"+strconv.Itoa(t.IPVersion))
+	}
+	return append(args, t.ExtraArgs...)
+}
+
+// ooklaProgress is a progress update printed by the Ookla CLI with
+// --format=json and progress enabled.
+type ooklaProgress struct {
+	Type string `json:"type"`
+	Ping struct {
+		Latency  float64 `json:"latency"`
+		Progress float64 `json:"progress"`
+	} `json:"ping"`
+	Download struct {
+		Bandwidth int64   `json:"bandwidth"`
+		Progress  float64 `json:"progress"`
+	} `json:"download"`
+	Upload struct {
+		Bandwidth int64   `json:"bandwidth"`
+		Progress  float64 `json:"progress"`
+	} `json:"upload"`
+}
+
+// logOoklaProgress logs one progress line; the final result and anything
+// that isn't a progress update are left to the parser.
+func logOoklaProgress(line []byte) {
+	var p ooklaProgress
+	if json.Unmarshal(line, &p) != nil {
+		return
+	}
+	switch p.Type {
+	case "ping":
+		slog.Info("Speed test progress", "phase", "ping", "progress", p.Ping.Progress, "latency_ms", p.Ping.Latency)
+	case "download":
+		slog.Info("Speed test progress", "phase", "download", "progress", p.Download.Progress,
+			"mbps", float64(p.Download.Bandwidth)*8/1_000_000)
+	case "upload":
+		slog.Info("Speed test progress", "phase", "upload", "progress", p.Upload.Progress,
+			"mbps", float64(p.Upload.Bandwidth)*8/1_000_000)
+	}
+}
+
+// licensePromptShown reports whether the CLI stopped to ask for license
+// acceptance, which happens on fresh installs.
+func licensePromptShown(output []byte) bool {
+	return strings.Contains(strings.ToLower(string(output)), "you may only use this speedtest software")
+}
+
+func (t *OoklaTester) Name() string { return "ookla" }
+
+// Run runs the Ookla CLI once and parses its JSON result. With several
+// ServerIDs, every run, retries included, moves on to the next server.
+func (t *OoklaTester) Run(ctx context.Context) (*FormattedSpeedTest, error) {
+	serverID := t.nextServerID()
+	result, err := t.run(ctx, serverID)
+	if err != nil && serverID != "" && t.ServerFallback && ctx.Err() == nil && !isPermanent(err) {
+		slog.Warn("Pinned speedtest server failed, falling back to automatic selection", "server_id", serverID, "error", err)
+		return t.run(ctx, "")
+	}
+	return result, err
+}
+
+// nextServerID returns the server for the next run in round-robin order, or
+// "" for automatic selection. Runs of one tester never overlap, so no
+// locking is needed.
+func (t *OoklaTester) nextServerID() string {
+	if len(t.ServerIDs) == 0 {
+		return ""
+	}
+	id := t.ServerIDs[t.next%len(t.ServerIDs)]
+	t.next++
+	return id
+}
+
+func (t *OoklaTester) run(ctx context.Context, serverID string) (*FormattedSpeedTest, error) {
+	var onLine func([]byte)
+	if t.Progress {
+		onLine = logOoklaProgress
+	}
+	output, stderr, err := runnerOrDefault(t.Runner).RunCommand(ctx, t.Timeout, t.Binary, t.args(serverID), onLine)
+	if ctx.Err() == nil && !t.AcceptLicense && (licensePromptShown(output) || licensePromptShown(stderr)) {
+		return nil, permanent(fmt.Errorf("speedtest is waiting for the Ookla license/GDPR to be accepted; run it once by hand or enable -accept-license"))
+	}
+	parse := func(output []byte) (*FormattedSpeedTest, error) {
+		return parseSpeedTestOutput(output, t.Fields, t.MaxPlausibleMbps)
+	}
+	var result *FormattedSpeedTest
+	if err != nil {
+		result, err = parseDespiteExit(t.Binary, output, err, parse)
+	} else {
+		result, err = parse(output)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.Raw = output
+	return result, nil
+}
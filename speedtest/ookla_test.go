@@ -0,0 +1,117 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+const validOoklaOutput = `{"type":"result","timestamp":"2024-06-01T10:00:00Z","ping":{"jitter":1.5,"latency":12.3},"download":{"bandwidth":12500000},"upload":{"bandwidth":2500000},"packetLoss":0,"isp":"Acme","server":{"id":1234,"name":"Town","location":"Springfield","country":"US"}}`
+
+// fakeRunner returns canned output and counts how often it was called.
+func fakeRunner(stdout, stderr string, err error, calls *int) CommandRunner {
+	return CommandRunnerFunc(func(ctx context.Context, timeout time.Duration, binary string, args []string, onLine func([]byte)) ([]byte, []byte, error) {
+		*calls++
+		return []byte(stdout), []byte(stderr), err
+	})
+}
+
+func TestOoklaTester(t *testing.T) {
+	tests := []struct {
+		name   string
+		stdout string
+		stderr string
+		err    error
+		// wantErr is empty when the run should succeed.
+		wantErr   string
+		permanent bool
+	}{
+		{
+			name:   "valid JSON",
+			stdout: validOoklaOutput,
+		},
+		{
+			name:   "banner before JSON",
+			stdout: "Speedtest by Ookla\n\n" + validOoklaOutput + "\n",
+		},
+		{
+			name:    "offline",
+			stdout:  `{"type":"log","timestamp":"2024-06-01T10:00:00Z","message":"Configuration - Couldn't resolve host name (HostNotFoundException)","level":"error"}`,
+			err:     errors.New("error running speedtest: exit status 2"),
+			wantErr: "exit status 2",
+		},
+		{
+			name:      "license not accepted",
+			stderr:    "You may only use this Speedtest software and information generated from it for personal, non-commercial use",
+			err:       errors.New("error running speedtest: exit status 1"),
+			wantErr:   "license",
+			permanent: true,
+		},
+		{
+			name:      "binary missing",
+			err:       permanent(fmt.Errorf("error starting speedtest: %w", ErrBinaryNotFound)),
+			wantErr:   "not found",
+			permanent: true,
+		},
+		{
+			name:    "malformed JSON",
+			stdout:  `{"type":"result","ping":{"latency":`,
+			wantErr: "no valid speed test result",
+		},
+		{
+			name:    "zero bandwidth",
+			stdout:  `{"type":"result","timestamp":"2024-06-01T10:00:00Z","ping":{"latency":12.3},"download":{"bandwidth":0},"upload":{"bandwidth":2500000}}`,
+			wantErr: "download bandwidth is 0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			tester := &OoklaTester{Binary: "speedtest", Runner: fakeRunner(tt.stdout, tt.stderr, tt.err, &calls)}
+
+			result, err := tester.Run(context.Background())
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Run() error = %v", err)
+				}
+				if result.DownloadMbps != 100 || result.UploadMbps != 20 || result.PingMs != 12.3 {
+					t.Errorf("Run() = %+v, want 100/20 Mbps and 12.3 ms", result)
+				}
+				if result.ServerID != "1234" || result.ServerLocation != "Springfield, US" {
+					t.Errorf("Run() server = %q at %q", result.ServerID, result.ServerLocation)
+				}
+			} else if err == nil || !containsFold(err.Error(), tt.wantErr) {
+				t.Fatalf("Run() error = %v, want one containing %q", err, tt.wantErr)
+			}
+
+			calls = 0
+			policy := RetryPolicy{MaxRetries: 3}
+			result, err = RunWithRetry(context.Background(), policy, tester)
+			switch {
+			case tt.wantErr == "":
+				if err != nil || result.Backend != "ookla" {
+					t.Fatalf("RunWithRetry() = %+v, %v", result, err)
+				}
+				if calls != 1 {
+					t.Errorf("RunWithRetry() ran %d times, want 1", calls)
+				}
+			case tt.permanent:
+				if !isPermanent(err) || calls != 1 {
+					t.Errorf("RunWithRetry() ran %d times with %v, want a single permanent failure", calls, err)
+				}
+			default:
+				var retryErr *RetryError
+				if !errors.As(err, &retryErr) || retryErr.Attempts != 3 || calls != 3 {
+					t.Errorf("RunWithRetry() ran %d times with %v, want 3 attempts", calls, err)
+				}
+			}
+		})
+	}
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
@@ -0,0 +1,76 @@
+package speedtest
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// pingCount is the number of echo requests sent per latency test.
+const pingCount = 10
+
+var (
+	pingLossPattern = regexp.MustCompile(`([\d.]+)% packet loss`)
+	// Matches the summary of Linux ("rtt min/avg/max/mdev") and BSD/macOS
+	// ("round-trip min/avg/max/stddev") ping
+	pingRTTPattern = regexp.MustCompile(`= ([\d.]+)/([\d.]+)/([\d.]+)/([\d.]+) ms`)
+)
+
+// PingTester measures latency, jitter and packet loss only, using the system
+// ping command, for latency-only tests with backends that have no ping-only
+// mode.
+type PingTester struct {
+	// Host is the host pinged.
+	Host string
+	// Timeout bounds a single run of ping.
+	Timeout time.Duration
+	// IPVersion forces IPv4 or IPv6 when set to 4 or 6.
+	IPVersion int
+	// Runner runs ping; nil means DefaultRunner.
+	Runner CommandRunner
+}
+
+func (t *PingTester) Name() string { return "ping" }
+
+// Run pings the host pingCount times and parses the summary.
+func (t *PingTester) Run(ctx context.Context) (*FormattedSpeedTest, error) {
+	now := time.Now()
+	args := []string{"-c", strconv.Itoa(pingCount), "-q"}
+	if t.IPVersion != 0 {
+		args = append(args, "-"+strconv.Itoa(t.IPVersion))
+	}
+	output, _, err := runnerOrDefault(t.Runner).RunCommand(ctx, t.Timeout, "ping", append(args, t.Host), nil)
+	if err != nil {
+		return nil, err
+	}
+	result, err := parsePingOutput(output)
+	if err != nil {
+		return nil, err
+	}
+	result.Timestamp = now.UTC().Format(time.RFC3339)
+	result.ServerName = t.Host
+	result.Raw = output
+	return result, nil
+}
+
+func parsePingOutput(output []byte) (*FormattedSpeedTest, error) {
+	loss := pingLossPattern.FindSubmatch(output)
+	rtt := pingRTTPattern.FindSubmatch(output)
+	if loss == nil || rtt == nil {
+		return nil, fmt.Errorf("no ping summary found in output")
+	}
+	packetLoss, _ := strconv.ParseFloat(string(loss[1]), 64)
+	avg, _ := strconv.ParseFloat(string(rtt[2]), 64)
+	jitter, _ := strconv.ParseFloat(string(rtt[4]), 64)
+	if avg <= 0 {
+		return nil, fmt.Errorf("invalid ping result: latency is %v ms", avg)
+	}
+	return &FormattedSpeedTest{
+		PingMs:      avg,
+		JitterMs:    jitter,
+		PacketLoss:  packetLoss,
+		LatencyOnly: true,
+	}, nil
+}
@@ -0,0 +1,122 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// RetryPolicy controls how many attempts RunWithRetry makes and how long it
+// waits between them.
+type RetryPolicy struct {
+	MaxRetries int
+	RetryDelay time.Duration
+	// Backoff doubles the delay after every failed attempt, up to MaxDelay.
+	Backoff  bool
+	MaxDelay time.Duration
+}
+
+// delay returns how long to wait before the given attempt (1 is the first retry).
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	if !p.Backoff {
+		return p.RetryDelay
+	}
+	wait := p.RetryDelay
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if wait >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return wait
+}
+
+// RunOnce runs a single Ookla speed test with the speedtest binary on the
+// PATH and DefaultTimeout, without retries.
+func RunOnce(ctx context.Context) (*FormattedSpeedTest, error) {
+	return run(ctx, &OoklaTester{Binary: "speedtest", Timeout: DefaultTimeout})
+}
+
+// run runs tester once and fills in the backend and duration of the result.
+func run(ctx context.Context, tester Tester) (*FormattedSpeedTest, error) {
+	start := time.Now()
+	result, err := tester.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result.Backend = tester.Name()
+	result.DurationMs = time.Since(start).Milliseconds()
+	return result, nil
+}
+
+// RunWithRetry runs the test until it succeeds or the attempts run out.
+// Cancelling ctx stops the running test and any pending retry straight away.
+// When every attempt fails, the error is a *RetryError.
+func RunWithRetry(ctx context.Context, policy RetryPolicy, tester Tester) (*FormattedSpeedTest, error) {
+	maxRetries := policy.MaxRetries
+	var lastErr error
+	for i := 0; i < maxRetries; i++ {
+		if i > 0 {
+			wait := policy.delay(i)
+			slog.Info("Retrying speed test", "backend", tester.Name(), "attempt", i+1, "max_attempts", maxRetries, "wait", wait, "error", lastErr)
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, fmt.Errorf("speedtest cancelled: %w", ctx.Err())
+			}
+		}
+
+		result, err := run(ctx, tester)
+		if err == nil {
+			if i > 0 {
+				slog.Info("Speed test succeeded after retrying", "backend", tester.Name(), "retries", i)
+			}
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if isPermanent(err) {
+			return nil, &RetryError{Attempts: i + 1, Err: err}
+		}
+		lastErr = err
+		slog.Warn("Speed test attempt failed", "backend", tester.Name(), "attempt", i+1, "max_attempts", maxRetries, "error", err)
+	}
+	return nil, &RetryError{Attempts: maxRetries, Err: lastErr}
+}
+
+// RetryError is the final error of RunWithRetry, recording how many
+// attempts were made before giving up.
+type RetryError struct {
+	Attempts int
+	// Err is the error of the last attempt.
+	Err error
+}
+
+func (e *RetryError) Error() string {
+	if isPermanent(e.Err) {
+		return "not retrying: " + e.Err.Error()
+	}
+	return fmt.Sprintf("failed after %d retries, last error: %v", e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error { return e.Err }
+
+// ErrorCategory classifies a failed test: permanent errors that aren't
+// retried, timeouts, non-zero CLI exits and everything else, such as
+// unparseable output.
+func ErrorCategory(err error) string {
+	switch {
+	case isPermanent(err):
+		return "permanent"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case exitedWithError(err):
+		return "exit"
+	}
+	return "other"
+}
@@ -0,0 +1,42 @@
+package speedtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// cancellingTester fails every run and cancels the context shortly after, once
+// the retry loop is waiting for the next attempt.
+type cancellingTester struct {
+	cancel context.CancelFunc
+	calls  int
+}
+
+func (t *cancellingTester) Name() string { return "ookla" }
+
+func (t *cancellingTester) Run(ctx context.Context) (*FormattedSpeedTest, error) {
+	t.calls++
+	time.AfterFunc(50*time.Millisecond, t.cancel)
+	return nil, errors.New("error running speedtest: exit status 2")
+}
+
+func TestRunWithRetryCancelledDuringDelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tester := &cancellingTester{cancel: cancel}
+	policy := RetryPolicy{MaxRetries: 3, RetryDelay: time.Hour}
+
+	start := time.Now()
+	_, err := RunWithRetry(ctx, policy, tester)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("RunWithRetry() returned after %v, want promptly after cancelling", elapsed)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("RunWithRetry() error = %v, want context.Canceled", err)
+	}
+	if tester.calls != 1 {
+		t.Errorf("RunWithRetry() ran %d times, want 1", tester.calls)
+	}
+}
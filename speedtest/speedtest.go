@@ -0,0 +1,279 @@
+// Package speedtest runs speed tests through the Ookla speedtest CLI,
+// librespeed-cli or ping and parses them into a FormattedSpeedTest. It is the
+// measurement part of speedtest-cron, usable on its own: RunOnce runs one
+// test with the defaults, and the testers and RunWithRetry give full control.
+package speedtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds a single CLI run when a tester has no Timeout set.
+const DefaultTimeout = 120 * time.Second
+
+// Tester measures the connection once using one speed test backend.
+type Tester interface {
+	// Name identifies the backend, e.g. in the backend column.
+	Name() string
+	Run(ctx context.Context) (*FormattedSpeedTest, error)
+}
+
+// SpeedTestResult is the "result" object printed by the Ookla CLI with
+// --format=json.
+type SpeedTestResult struct {
+	Type      string    `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Ping      struct {
+		Jitter  float64 `json:"jitter"`
+		Latency float64 `json:"latency"`
+	} `json:"ping"`
+	Download struct {
+		Bandwidth int64 `json:"bandwidth"`
+	} `json:"download"`
+	Upload struct {
+		Bandwidth int64 `json:"bandwidth"`
+	} `json:"upload"`
+	PacketLoss float64 `json:"packetLoss"`
+	ISP        string  `json:"isp"`
+	Server     struct {
+		ID       int    `json:"id"`
+		Name     string `json:"name"`
+		Location string `json:"location"`
+		Country  string `json:"country"`
+		Host     string `json:"host"`
+		// Distance in km is only reported by some CLI versions
+		Distance float64 `json:"distance"`
+	} `json:"server"`
+}
+
+// FieldMap gives the dotted paths, such as "download.bandwidth", of the values
+// read from the Ookla CLI's JSON result, for forks and versions whose keys
+// differ. Ping is in ms and download and upload in bytes/s, as the official
+// CLI reports them. Empty paths use DefaultFieldMap.
+type FieldMap struct {
+	Ping     string
+	Download string
+	Upload   string
+}
+
+// DefaultFieldMap matches the official Ookla CLI.
+var DefaultFieldMap = FieldMap{
+	Ping:     "ping.latency",
+	Download: "download.bandwidth",
+	Upload:   "upload.bandwidth",
+}
+
+// withDefaults fills in empty paths from DefaultFieldMap.
+func (m FieldMap) withDefaults() FieldMap {
+	if m.Ping == "" {
+		m.Ping = DefaultFieldMap.Ping
+	}
+	if m.Download == "" {
+		m.Download = DefaultFieldMap.Download
+	}
+	if m.Upload == "" {
+		m.Upload = DefaultFieldMap.Upload
+	}
+	return m
+}
+
+// apply overwrites the mapped values of result with the numbers found at
+// the paths in the generically parsed object. Missing values read as zero
+// and are rejected by validate.
+func (m FieldMap) apply(object map[string]interface{}, result *SpeedTestResult) {
+	m = m.withDefaults()
+	result.Ping.Latency = lookupNumber(object, m.Ping)
+	result.Download.Bandwidth = int64(lookupNumber(object, m.Download))
+	result.Upload.Bandwidth = int64(lookupNumber(object, m.Upload))
+}
+
+// lookupNumber follows a dotted path through nested JSON objects and returns
+// the number at its end, or zero if there is none.
+func lookupNumber(object map[string]interface{}, path string) float64 {
+	keys := strings.Split(path, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := object[key].(map[string]interface{})
+		if !ok {
+			return 0
+		}
+		object = next
+	}
+	n, _ := object[keys[len(keys)-1]].(float64)
+	return n
+}
+
+// FormattedSpeedTest is a backend-independent speed test result as written
+// to every output.
+type FormattedSpeedTest struct {
+	Timestamp    string  `json:"timestamp"`
+	PingMs       float64 `json:"ping_ms"`
+	DownloadMbps float64 `json:"download_mbps,omitempty"`
+	UploadMbps   float64 `json:"upload_mbps,omitempty"`
+	JitterMs     float64 `json:"jitter_ms"`
+	PacketLoss   float64 `json:"packet_loss"`
+	ServerName   string  `json:"server_name"`
+	ServerID     string  `json:"server_id"`
+	ISP          string  `json:"isp"`
+	Backend      string  `json:"backend"`
+	// Label identifies the machine or site that took the measurement when
+	// results from many monitors are aggregated; set by the caller.
+	Label string `json:"label"`
+	// Tags are extra key=value metadata set by the caller.
+	Tags map[string]string `json:"tags,omitempty"`
+	// DurationMs is how long the successful run of the CLI took, excluding
+	// failed attempts and retry delays.
+	DurationMs int64 `json:"duration_ms"`
+	// RecordedAt is the host's clock when the result was recorded, set by
+	// the caller; Timestamp is the time reported by the CLI.
+	RecordedAt string `json:"recorded_at"`
+
+	// The fields below are not part of the JSON outputs.
+
+	// LatencyOnly is set by latency-only tests, which leave the bandwidth
+	// unmeasured.
+	LatencyOnly bool `json:"-"`
+	// ServerLocation and ServerDistanceKm describe the chosen server; a zero
+	// distance means the CLI didn't report it.
+	ServerLocation   string  `json:"-"`
+	ServerDistanceKm float64 `json:"-"`
+	// Raw is the CLI output the result was parsed from.
+	Raw []byte `json:"-"`
+}
+
+// parseSpeedTestOutput parses the Ookla CLI's JSON output, reading ping and
+// bandwidth from where fields says. Results above maxMbps are rejected as
+// implausible unless maxMbps is zero.
+func parseSpeedTestOutput(output []byte, fields FieldMap, maxMbps float64) (*FormattedSpeedTest, error) {
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if result, ok := decodeResult([]byte(line), fields); ok {
+			return formatResult(result, maxMbps)
+		}
+	}
+
+	// No line holds the whole result, e.g. when a banner runs into the JSON
+	// or the JSON spans several lines; try everything from the first { to
+	// the last }, moving on to the next { if the banner itself has braces
+	end := bytes.LastIndexByte(output, '}')
+	for start := bytes.IndexByte(output, '{'); start >= 0 && start < end; {
+		if result, ok := decodeResult(output[start:end+1], fields); ok {
+			slog.Info("Trimmed non-JSON output around the speed test result", "trimmed_bytes", len(output)-(end+1-start))
+			return formatResult(result, maxMbps)
+		}
+		next := bytes.IndexByte(output[start+1:], '{')
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+
+	return nil, fmt.Errorf("no valid speed test result found in output")
+}
+
+// decodeResult parses data as a JSON object and reports whether it is the
+// CLI's "result" entry. The mapped values are read generically so keys that
+// moved don't fail the whole object.
+func decodeResult(data []byte, fields FieldMap) (SpeedTestResult, bool) {
+	var result SpeedTestResult
+	var object map[string]interface{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return result, false
+	}
+	// A mapped key that now holds another type, such as a number where the
+	// struct expects an object, only leaves that field unset
+	var typeErr *json.UnmarshalTypeError
+	if err := json.Unmarshal(data, &result); err != nil && !errors.As(err, &typeErr) {
+		return result, false
+	}
+	fields.apply(object, &result)
+
+	// Only process "result" type entries
+	return result, result.Type == "result"
+}
+
+// formatResult validates the CLI's result and converts it.
+func formatResult(result SpeedTestResult, maxMbps float64) (*FormattedSpeedTest, error) {
+	if err := validate(result, maxMbps); err != nil {
+		return nil, fmt.Errorf("invalid speed test result: %w", err)
+	}
+
+	// Some CLI versions omit the server block; leave the ID empty then
+	serverID := ""
+	if result.Server.ID != 0 {
+		serverID = strconv.Itoa(result.Server.ID)
+	}
+
+	downloadMbps := bytesToMbps(result.Download.Bandwidth)
+	uploadMbps := bytesToMbps(result.Upload.Bandwidth)
+
+	return &FormattedSpeedTest{
+		Timestamp:    result.Timestamp.Format(time.RFC3339),
+		PingMs:       result.Ping.Latency,
+		DownloadMbps: downloadMbps,
+		UploadMbps:   uploadMbps,
+		JitterMs:     result.Ping.Jitter,
+		PacketLoss:   result.PacketLoss,
+		ServerName:   result.Server.Name,
+		ServerID:     serverID,
+		ISP:          result.ISP,
+
+		ServerLocation:   joinLocation(result.Server.Location, result.Server.Country),
+		ServerDistanceKm: result.Server.Distance,
+	}, nil
+}
+
+// ServerLocation joins the server's city and country, skipping empty parts.
+func joinLocation(location, country string) string {
+	switch {
+	case location == "":
+		return country
+	case country == "":
+		return location
+	}
+	return location + ", " + country
+}
+
+// validate returns the first reason the result can't be a real measurement,
+// such as zero bandwidth or a non-positive latency from malformed CLI output,
+// or bandwidth above maxMbps from a CLI bug.
+func validate(result SpeedTestResult, maxMbps float64) error {
+	switch {
+	case result.Download.Bandwidth <= 0:
+		return fmt.Errorf("download bandwidth is %d bytes/s", result.Download.Bandwidth)
+	case result.Upload.Bandwidth <= 0:
+		return fmt.Errorf("upload bandwidth is %d bytes/s", result.Upload.Bandwidth)
+	case result.Ping.Latency <= 0:
+		return fmt.Errorf("ping latency is %v ms", result.Ping.Latency)
+	}
+	return checkPlausible(bytesToMbps(result.Download.Bandwidth), bytesToMbps(result.Upload.Bandwidth), maxMbps)
+}
+
+// checkPlausible rejects bandwidth above maxMbps, which no real link
+// reaches; a zero maxMbps disables the check.
+func checkPlausible(downloadMbps, uploadMbps, maxMbps float64) error {
+	switch {
+	case maxMbps <= 0:
+		return nil
+	case downloadMbps > maxMbps:
+		return fmt.Errorf("download %.2f Mbps is above the plausible maximum of %.2f Mbps", downloadMbps, maxMbps)
+	case uploadMbps > maxMbps:
+		return fmt.Errorf("upload %.2f Mbps is above the plausible maximum of %.2f Mbps", uploadMbps, maxMbps)
+	}
+	return nil
+}
+
+// bytesToMbps converts the CLI's bandwidth in bytes/s to Mbps.
+func bytesToMbps(bandwidth int64) float64 {
+	return float64(bandwidth) * 8 / 1_000_000
+}
@@ -0,0 +1,95 @@
+package speedtest
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		maxMbps float64
+		// wantErr is empty when the result is valid.
+		wantErr string
+	}{
+		{
+			name: "valid",
+			json: validOoklaOutput,
+		},
+		{
+			name:    "zero download",
+			json:    `{"type":"result","ping":{"latency":12.3},"download":{"bandwidth":0},"upload":{"bandwidth":2500000}}`,
+			wantErr: "download bandwidth is 0",
+		},
+		{
+			name:    "missing upload",
+			json:    `{"type":"result","ping":{"latency":12.3},"download":{"bandwidth":12500000}}`,
+			wantErr: "upload bandwidth is 0",
+		},
+		{
+			name:    "negative upload",
+			json:    `{"type":"result","ping":{"latency":12.3},"download":{"bandwidth":12500000},"upload":{"bandwidth":-1}}`,
+			wantErr: "upload bandwidth is -1",
+		},
+		{
+			name:    "zero ping",
+			json:    `{"type":"result","ping":{"latency":0},"download":{"bandwidth":12500000},"upload":{"bandwidth":2500000}}`,
+			wantErr: "ping latency is 0",
+		},
+		{
+			name:    "implausible download",
+			json:    validOoklaOutput,
+			maxMbps: 50,
+			wantErr: "above the plausible maximum",
+		},
+		{
+			name:    "within plausible maximum",
+			json:    validOoklaOutput,
+			maxMbps: 1000,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, ok := decodeResult([]byte(tt.json), DefaultFieldMap)
+			if !ok {
+				t.Fatalf("decodeResult() could not decode %s", tt.json)
+			}
+			err := validate(result, tt.maxMbps)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("validate() error = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("validate() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseSpeedTestOutputAroundBanner(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+	}{
+		{"banner line", "Speedtest by Ookla\n\n" + validOoklaOutput + "\n"},
+		{"banner on the same line", "Speedtest by Ookla" + validOoklaOutput},
+		{"banner with braces", "Speedtest {beta} by Ookla " + validOoklaOutput + " done"},
+		{"trailing log line", validOoklaOutput + "\n" + `{"type":"log","message":"done"}`},
+		{"JSON across lines", "==> Result\n" + strings.Replace(validOoklaOutput, ",", ",\n", -1) + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := parseSpeedTestOutput([]byte(tt.output), DefaultFieldMap, 0)
+			if err != nil {
+				t.Fatalf("parseSpeedTestOutput() error = %v", err)
+			}
+			if result.DownloadMbps != 100 || result.UploadMbps != 20 || result.PingMs != 12.3 || result.ISP != "Acme" {
+				t.Errorf("parseSpeedTestOutput() = %+v", result)
+			}
+		})
+	}
+
+	if _, err := parseSpeedTestOutput([]byte("Speedtest by Ookla\nno result {here}\n"), DefaultFieldMap, 0); err == nil {
+		t.Error("parseSpeedTestOutput() of a banner without a result succeeded")
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"speedtest-cron/speedtest"
+)
+
+// resultsColumns defines the results table. The bandwidth columns are NULL
+// for latency-only results, and tags holds the -tag values as a JSON object,
+// or NULL without tags.
+const resultsColumns = `
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	timestamp     TEXT NOT NULL,
+	ping_ms       REAL NOT NULL,
+	download_mbps REAL,
+	upload_mbps   REAL,
+	jitter_ms     REAL NOT NULL,
+	packet_loss   REAL NOT NULL,
+	server_name   TEXT NOT NULL,
+	server_id     TEXT NOT NULL,
+	isp           TEXT NOT NULL,
+	backend       TEXT NOT NULL DEFAULT '',
+	label         TEXT NOT NULL DEFAULT '',
+	duration_ms   INTEGER NOT NULL DEFAULT 0,
+	recorded_at   TEXT NOT NULL DEFAULT '',
+	tags          TEXT
+`
+
+const createResultsTable = `CREATE TABLE IF NOT EXISTS results (` + resultsColumns + `)`
+
+// addedResultsColumns are the columns added to the results table since it
+// was first released, with their definitions for ALTER TABLE.
+var addedResultsColumns = []struct{ name, definition string }{
+	{"backend", "TEXT NOT NULL DEFAULT ''"},
+	{"label", "TEXT NOT NULL DEFAULT ''"},
+	{"duration_ms", "INTEGER NOT NULL DEFAULT 0"},
+	{"recorded_at", "TEXT NOT NULL DEFAULT ''"},
+	{"tags", "TEXT"},
+}
+
+// rebuildResultsTable copies the results table into one with the current
+// definition, for changes ALTER TABLE can't make in SQLite.
+const rebuildResultsTable = `
+CREATE TABLE results_new (` + resultsColumns + `);
+INSERT INTO results_new
+	(id, timestamp, ping_ms, download_mbps, upload_mbps, jitter_ms, packet_loss, server_name, server_id, isp, backend, label, duration_ms, recorded_at, tags)
+	SELECT id, timestamp, ping_ms, download_mbps, upload_mbps, jitter_ms, packet_loss, server_name, server_id, isp, backend, label, duration_ms, recorded_at, tags
+	FROM results;
+DROP TABLE results;
+ALTER TABLE results_new RENAME TO results;`
+
+const insertResult = `INSERT INTO results
+	(timestamp, ping_ms, download_mbps, upload_mbps, jitter_ms, packet_loss, server_name, server_id, isp, backend, label, duration_ms, recorded_at, tags)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// SQLiteWriter stores each result as a row in the results table.
+type SQLiteWriter struct {
+	db     *sql.DB
+	insert *sql.Stmt
+}
+
+// ensureSQLiteDB opens the database at filename, creating the file and the
+// results table if they don't exist yet and migrating a table created by an
+// earlier version.
+func ensureSQLiteDB(filename string) (*sql.DB, error) {
+	if err := ensureDir(filename); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		return nil, fmt.Errorf("error opening SQLite database: %w", err)
+	}
+	// A single connection is kept open for the lifetime of the process.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(createResultsTable); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating results table: %w", err)
+	}
+	if err := migrateResultsTable(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrateResultsTable adds the addedResultsColumns the results table lacks,
+// where existing rows get the column default, and rebuilds a table whose
+// bandwidth columns are still NOT NULL.
+func migrateResultsTable(db *sql.DB) error {
+	rows, err := db.Query("SELECT name, \"notnull\" FROM pragma_table_info('results')")
+	if err != nil {
+		return fmt.Errorf("error reading results table columns: %w", err)
+	}
+	defer rows.Close()
+	// existing maps each column to whether it is NOT NULL
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		var notNull bool
+		if err := rows.Scan(&name, &notNull); err != nil {
+			return fmt.Errorf("error reading results table columns: %w", err)
+		}
+		existing[name] = notNull
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading results table columns: %w", err)
+	}
+	rows.Close()
+
+	for _, column := range addedResultsColumns {
+		if _, ok := existing[column.name]; ok {
+			continue
+		}
+		if _, err := db.Exec("ALTER TABLE results ADD COLUMN " + column.name + " " + column.definition); err != nil {
+			return fmt.Errorf("error adding %s column to results table: %w", column.name, err)
+		}
+	}
+
+	if !existing["download_mbps"] && !existing["upload_mbps"] {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error rebuilding results table: %w", err)
+	}
+	if _, err := tx.Exec(rebuildResultsTable); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("error rebuilding results table: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error rebuilding results table: %w", err)
+	}
+	slog.Info("Made the bandwidth columns of the SQLite results table nullable for latency-only results")
+	return nil
+}
+
+// bandwidthValues returns the download and upload columns of result for the
+// SQL outputs: NULL for latency-only results, which don't measure them.
+func bandwidthValues(result *speedtest.FormattedSpeedTest) (download, upload sql.NullFloat64) {
+	if result.LatencyOnly {
+		return download, upload
+	}
+	return sql.NullFloat64{Float64: result.DownloadMbps, Valid: true}, sql.NullFloat64{Float64: result.UploadMbps, Valid: true}
+}
+
+// tagsValue returns the tags column of result for the SQL outputs: a JSON
+// object, or NULL without tags.
+func tagsValue(result *speedtest.FormattedSpeedTest) (sql.NullString, error) {
+	if len(result.Tags) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(result.Tags)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("error encoding tags: %w", err)
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func newSQLiteWriter(filename string) (*SQLiteWriter, error) {
+	db, err := ensureSQLiteDB(filename)
+	if err != nil {
+		return nil, err
+	}
+	insert, err := db.Prepare(insertResult)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error preparing insert statement: %w", err)
+	}
+	return &SQLiteWriter{db: db, insert: insert}, nil
+}
+
+func (w *SQLiteWriter) Write(result *speedtest.FormattedSpeedTest) error {
+	download, upload := bandwidthValues(result)
+	tags, err := tagsValue(result)
+	if err != nil {
+		return err
+	}
+	_, err = w.insert.Exec(
+		result.Timestamp,
+		result.PingMs,
+		download,
+		upload,
+		result.JitterMs,
+		result.PacketLoss,
+		result.ServerName,
+		result.ServerID,
+		result.ISP,
+		result.Backend,
+		result.Label,
+		result.DurationMs,
+		result.RecordedAt,
+		tags,
+	)
+	if err != nil {
+		return fmt.Errorf("error inserting into SQLite: %w", err)
+	}
+	return nil
+}
+
+func (w *SQLiteWriter) Close() error {
+	w.insert.Close()
+	return w.db.Close()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSQLiteWriterMigratesTable(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "results.db")
+	// The results table as created before it had backend and label columns
+	// and nullable bandwidth
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`CREATE TABLE results (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, timestamp TEXT NOT NULL, ping_ms REAL NOT NULL,
+		download_mbps REAL NOT NULL, upload_mbps REAL NOT NULL, jitter_ms REAL NOT NULL,
+		packet_loss REAL NOT NULL, server_name TEXT NOT NULL, server_id TEXT NOT NULL, isp TEXT NOT NULL)`)
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO results VALUES (1, '2024-05-01T10:00:00Z', 10, 90, 18, 1, 0, 'Town', '1234', 'Acme')`)
+	}
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := newSQLiteWriter(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Write(testResult()); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	latencyOnly := testResult()
+	latencyOnly.LatencyOnly = true
+	latencyOnly.Tags = map[string]string{"site": "attic", "isp_plan": "100/20"}
+	if err := w.Write(latencyOnly); err != nil {
+		t.Fatalf("Write() of a latency-only result error = %v", err)
+	}
+
+	rows, err := w.db.Query("SELECT backend, label, download_mbps, duration_ms, recorded_at, tags FROM results ORDER BY id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var backend, label, recordedAt string
+		var download sql.NullFloat64
+		var durationMs int64
+		var tags sql.NullString
+		if err := rows.Scan(&backend, &label, &download, &durationMs, &recordedAt, &tags); err != nil {
+			t.Fatal(err)
+		}
+		row := fmt.Sprintf("%s|%s|%s|%d|%s|%s", backend, label, nullString(download.Valid, fmt.Sprint(download.Float64)),
+			durationMs, recordedAt, nullString(tags.Valid, tags.String))
+		got = append(got, row)
+	}
+	want := []string{
+		"||90|0||NULL",
+		"ookla|home|100|15000|2024-06-01T10:00:01Z|NULL",
+		`ookla|home|NULL|15000|2024-06-01T10:00:01Z|{"isp_plan":"100/20","site":"attic"}`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("rows =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// nullString returns s, or NULL for a NULL column.
+func nullString(valid bool, s string) string {
+	if !valid {
+		return "NULL"
+	}
+	return s
+}
+
+func TestSQLiteWriterReopen(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "results.db")
+	for i := 0; i < 2; i++ {
+		w, err := newSQLiteWriter(filename)
+		if err != nil {
+			t.Fatalf("newSQLiteWriter() on open %d: %v", i+1, err)
+		}
+		if err := w.Write(testResult()); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM results WHERE duration_ms = 15000").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("got %d rows, want 2", count)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"speedtest-cron/speedtest"
+)
+
+// monitorState is the part of the monitor persisted across restarts, so
+// change detection and recovery alerts keep working after a restart. Both
+// maps are keyed by backend.
+type monitorState struct {
+	LastResults map[string]*speedtest.FormattedSpeedTest `json:"last_results,omitempty"`
+	Failures    map[string]int                           `json:"backend_failures,omitempty"`
+
+	// LegacyLastResult and LegacyFailures are the single, shared state
+	// written by earlier versions; see migrate.
+	LegacyLastResult *speedtest.FormattedSpeedTest `json:"last_result,omitempty"`
+	LegacyFailures   int                           `json:"failures,omitempty"`
+}
+
+// migrate moves state in the earlier single-backend format into the
+// per-backend maps. The failures are attributed to backend, and so is a
+// last result that doesn't name its own.
+func (s *monitorState) migrate(backend string) {
+	if s.LegacyLastResult != nil {
+		key := s.LegacyLastResult.Backend
+		if key == "" {
+			key = backend
+		}
+		if _, ok := s.LastResults[key]; !ok {
+			if s.LastResults == nil {
+				s.LastResults = make(map[string]*speedtest.FormattedSpeedTest)
+			}
+			s.LastResults[key] = s.LegacyLastResult
+		}
+	}
+	if s.LegacyFailures > 0 {
+		if _, ok := s.Failures[backend]; !ok {
+			if s.Failures == nil {
+				s.Failures = make(map[string]int)
+			}
+			s.Failures[backend] = s.LegacyFailures
+		}
+	}
+	s.LegacyLastResult, s.LegacyFailures = nil, 0
+}
+
+// loadState reads the state written by saveState.
+func loadState(filename string) (*monitorState, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var state monitorState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error decoding state file: %w", err)
+	}
+	return &state, nil
+}
+
+// saveState writes state to a temporary file next to filename and renames
+// it into place, so a crash mid-write never leaves a truncated state file.
+func saveState(filename string, state *monitorState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("error encoding state: %w", err)
+	}
+	if err := ensureDir(filename); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("error replacing state file: %w", err)
+	}
+	return nil
+}
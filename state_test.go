@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"speedtest-cron/speedtest"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "state.json")
+	state := &monitorState{
+		LastResults: map[string]*speedtest.FormattedSpeedTest{
+			"ookla":      {Backend: "ookla", DownloadMbps: 100},
+			"librespeed": {Backend: "librespeed", DownloadMbps: 80},
+		},
+		Failures: map[string]int{"librespeed": 2},
+	}
+	if err := saveState(filename, state); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadState(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.migrate("ookla")
+	if len(got.LastResults) != 2 || got.LastResults["librespeed"].DownloadMbps != 80 {
+		t.Errorf("LastResults = %v", got.LastResults)
+	}
+	if len(got.Failures) != 1 || got.Failures["librespeed"] != 2 {
+		t.Errorf("Failures = %v, want librespeed: 2", got.Failures)
+	}
+}
+
+func TestStateMigratesSingleBackendFormat(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "state.json")
+	legacy := `{"last_result":{"timestamp":"2024-06-01T10:00:00Z","download_mbps":100,"backend":"ookla"},"failures":3}`
+	if err := os.WriteFile(filename, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	state, err := loadState(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.migrate("ookla")
+	if result := state.LastResults["ookla"]; result == nil || result.DownloadMbps != 100 {
+		t.Errorf("LastResults = %v, want the legacy result under ookla", state.LastResults)
+	}
+	if state.Failures["ookla"] != 3 {
+		t.Errorf("Failures = %v, want ookla: 3", state.Failures)
+	}
+	if state.LegacyLastResult != nil || state.LegacyFailures != 0 {
+		t.Error("migrate() left the legacy fields set")
+	}
+}
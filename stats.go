@@ -0,0 +1,43 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// maxSamples bounds the memory a sampleSet uses. At one test every few
+// minutes it is never reached; past it, a uniform reservoir sample is kept.
+const maxSamples = 10000
+
+// sampleSet retains the values of one metric for percentile calculations.
+type sampleSet struct {
+	values []float64
+	// seen counts every value added, including those not retained.
+	seen int
+}
+
+func (s *sampleSet) add(v float64) {
+	s.seen++
+	if len(s.values) < maxSamples {
+		s.values = append(s.values, v)
+		return
+	}
+	if i := rand.Intn(s.seen); i < maxSamples {
+		s.values[i] = v
+	}
+}
+
+// Percentile returns the p-th percentile (0 to 100) of the retained values,
+// interpolating linearly between the closest ranks. It is 0 for an empty set.
+func (s *sampleSet) Percentile(p float64) float64 {
+	if len(s.values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), s.values...)
+	sort.Float64s(sorted)
+	rank := math.Max(0, math.Min(100, p)) / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
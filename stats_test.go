@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		p      float64
+		want   float64
+	}{
+		{"empty", nil, 50, 0},
+		{"single value", []float64{42}, 95, 42},
+		{"median of odd count", []float64{5, 1, 3}, 50, 3},
+		{"median of even count", []float64{4, 1, 3, 2}, 50, 2.5},
+		{"minimum", []float64{30, 10, 20}, 0, 10},
+		{"maximum", []float64{30, 10, 20}, 100, 30},
+		{"interpolated", []float64{10, 20, 30, 40, 50}, 90, 46},
+		{"below range", []float64{10, 20}, -5, 10},
+		{"above range", []float64{10, 20}, 150, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var s sampleSet
+			for _, v := range tt.values {
+				s.add(v)
+			}
+			if got := s.Percentile(tt.p); got != tt.want {
+				t.Errorf("Percentile(%v) of %v = %v, want %v", tt.p, tt.values, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"speedtest-cron/speedtest"
+)
+
+// StatsDWriter sends download, upload and ping as StatsD gauges over UDP.
+// Sending is fire-and-forget: a lost packet or an unreachable daemon is
+// logged at debug level and never delays the other outputs.
+type StatsDWriter struct {
+	conn   net.Conn
+	prefix string
+}
+
+func newStatsDWriter(addr, prefix string) (*StatsDWriter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up StatsD at %s: %w", addr, err)
+	}
+	return &StatsDWriter{conn: conn, prefix: strings.TrimSuffix(prefix, ".")}, nil
+}
+
+func (w *StatsDWriter) Write(result *speedtest.FormattedSpeedTest) error {
+	var lines []string
+	gauge := func(name string, value float64) {
+		metric := name
+		if w.prefix != "" {
+			metric = w.prefix + "." + name
+		}
+		lines = append(lines, metric+":"+strconv.FormatFloat(value, 'f', -1, 64)+"|g")
+	}
+	if !result.LatencyOnly {
+		gauge("download", result.DownloadMbps)
+		gauge("upload", result.UploadMbps)
+	}
+	gauge("ping", result.PingMs)
+
+	// All gauges fit in one datagram
+	w.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if _, err := w.conn.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		slog.Debug("Error sending StatsD gauges", "addr", w.conn.RemoteAddr(), "error", err)
+	}
+	return nil
+}
+
+func (w *StatsDWriter) Close() error {
+	return w.conn.Close()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log/slog"
+
+	"speedtest-cron/speedtest"
+)
+
+// metricSummary tracks the minimum, maximum, mean and percentiles of one
+// metric.
+type metricSummary struct {
+	min, max, sum float64
+	count         int
+	samples       sampleSet
+}
+
+func (s *metricSummary) add(v float64) {
+	if s.count == 0 || v < s.min {
+		s.min = v
+	}
+	if s.count == 0 || v > s.max {
+		s.max = v
+	}
+	s.sum += v
+	s.count++
+	s.samples.add(v)
+}
+
+func (s *metricSummary) avg() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.sum / float64(s.count)
+}
+
+func (s *metricSummary) attrs(name string) slog.Attr {
+	return slog.Group(name, "min", s.min, "max", s.max, "avg", s.avg(),
+		"p50", s.samples.Percentile(50), "p90", s.samples.Percentile(90), "p99", s.samples.Percentile(99))
+}
+
+// sessionSummary accumulates statistics over the lifetime of the process
+// for the recap logged at shutdown.
+type sessionSummary struct {
+	tests    int
+	failures int
+	download metricSummary
+	upload   metricSummary
+	ping     metricSummary
+}
+
+func (s *sessionSummary) addResult(result *speedtest.FormattedSpeedTest) {
+	s.tests++
+	if !result.LatencyOnly {
+		s.download.add(result.DownloadMbps)
+		s.upload.add(result.UploadMbps)
+	}
+	s.ping.add(result.PingMs)
+}
+
+func (s *sessionSummary) addFailure() {
+	s.tests++
+	s.failures++
+}
+
+func (s *sessionSummary) log() {
+	if s.tests == s.failures {
+		slog.Info("Session summary", "tests", s.tests, "failures", s.failures)
+		return
+	}
+	slog.Info("Session summary",
+		"tests", s.tests,
+		"failures", s.failures,
+		s.download.attrs("download_mbps"),
+		s.upload.attrs("upload_mbps"),
+		s.ping.attrs("ping_ms"))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+
+	"speedtest-cron/speedtest"
+)
+
+// checkThresholds returns a human-readable description of every configured
+// threshold the result violates. Thresholds left at zero are not checked.
+func checkThresholds(result *speedtest.FormattedSpeedTest, cfg *Config) []string {
+	var violations []string
+	if cfg.MinDownload > 0 && !result.LatencyOnly && result.DownloadMbps < cfg.MinDownload {
+		violations = append(violations, fmt.Sprintf("download %.2f Mbps is below the minimum of %.2f Mbps", result.DownloadMbps, cfg.MinDownload))
+	}
+	if cfg.MinUpload > 0 && !result.LatencyOnly && result.UploadMbps < cfg.MinUpload {
+		violations = append(violations, fmt.Sprintf("upload %.2f Mbps is below the minimum of %.2f Mbps", result.UploadMbps, cfg.MinUpload))
+	}
+	if cfg.MaxPing > 0 && result.PingMs > cfg.MaxPing {
+		violations = append(violations, fmt.Sprintf("ping %.2f ms is above the maximum of %.2f ms", result.PingMs, cfg.MaxPing))
+	}
+	return violations
+}
+
+// detectDrops compares result with the previous one and describes every
+// bandwidth metric that fell by more than thresholdPct percent. There is
+// nothing to compare against for the first result, and a zero threshold
+// disables the check.
+func detectDrops(previous, result *speedtest.FormattedSpeedTest, thresholdPct float64) []string {
+	if previous == nil || thresholdPct <= 0 || result.LatencyOnly {
+		return nil
+	}
+	var drops []string
+	for _, metric := range []struct {
+		name          string
+		before, after float64
+	}{
+		{"download", previous.DownloadMbps, result.DownloadMbps},
+		{"upload", previous.UploadMbps, result.UploadMbps},
+	} {
+		if metric.before <= 0 {
+			continue
+		}
+		if change := (metric.before - metric.after) / metric.before * 100; change > thresholdPct {
+			drops = append(drops, fmt.Sprintf("%s dropped %.0f%% from %.2f to %.2f Mbps", metric.name, change, metric.before, metric.after))
+		}
+	}
+	return drops
+}
+
+// ispChanged reports whether result names a different ISP than previous,
+// which must be the previous result of the same backend since backends name
+// the same ISP differently. The first result, and results without an ISP
+// such as pings, never count.
+func ispChanged(previous, result *speedtest.FormattedSpeedTest) bool {
+	if previous == nil {
+		return false
+	}
+	return previous.ISP != "" && result.ISP != "" && previous.ISP != result.ISP
+}
+
+// checkServerDistance describes the server as too far away if the CLI
+// reported a distance above maxKm. Results without a distance, and a zero
+// maxKm, are never flagged.
+func checkServerDistance(result *speedtest.FormattedSpeedTest, maxKm float64) string {
+	if maxKm <= 0 || result.ServerDistanceKm <= maxKm {
+		return ""
+	}
+	where := result.ServerName
+	if result.ServerLocation != "" {
+		where += " (" + result.ServerLocation + ")"
+	}
+	return fmt.Sprintf("server %s is %.0f km away, more than the maximum of %.0f km", where, result.ServerDistanceKm, maxKm)
+}
@@ -0,0 +1,10 @@
+package main
+
+// Build metadata, stamped at build time with e.g.
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
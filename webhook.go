@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"speedtest-cron/speedtest"
+)
+
+// WebhookWriter POSTs each result as JSON to a URL. Requests are sent in the
+// background so a slow endpoint never delays the other outputs or the next
+// test.
+type WebhookWriter struct {
+	url     string
+	client  *http.Client
+	pending sync.WaitGroup
+}
+
+func newWebhookWriter(url string) *WebhookWriter {
+	return &WebhookWriter{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (w *WebhookWriter) Write(result *speedtest.FormattedSpeedTest) error {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("error encoding webhook payload: %w", err)
+	}
+
+	w.pending.Add(1)
+	go func() {
+		defer w.pending.Done()
+		if err := w.post(body); err != nil {
+			slog.Warn("Webhook delivery failed", "url", w.url, "error", err)
+		}
+	}()
+	return nil
+}
+
+func (w *WebhookWriter) post(body []byte) error {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %s", w.url, resp.Status)
+	}
+	return nil
+}
+
+// Close waits for in-flight deliveries; each is bounded by the client timeout.
+func (w *WebhookWriter) Close() error {
+	w.pending.Wait()
+	return nil
+}
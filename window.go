@@ -0,0 +1,45 @@
+package main
+
+import "speedtest-cron/speedtest"
+
+// rollingWindow keeps the most recent results in a fixed-size ring buffer.
+type rollingWindow struct {
+	results []*speedtest.FormattedSpeedTest
+	next    int
+	full    bool
+}
+
+func newRollingWindow(size int) *rollingWindow {
+	return &rollingWindow{results: make([]*speedtest.FormattedSpeedTest, size)}
+}
+
+// Add stores result, overwriting the oldest one once the window is full.
+func (w *rollingWindow) Add(result *speedtest.FormattedSpeedTest) {
+	w.results[w.next] = result
+	w.next = (w.next + 1) % len(w.results)
+	if w.next == 0 {
+		w.full = true
+	}
+}
+
+// Len returns the number of results currently in the window.
+func (w *rollingWindow) Len() int {
+	if w.full {
+		return len(w.results)
+	}
+	return w.next
+}
+
+// Average returns the mean download, upload and ping over the window.
+func (w *rollingWindow) Average() (downloadMbps, uploadMbps, pingMs float64) {
+	n := w.Len()
+	if n == 0 {
+		return 0, 0, 0
+	}
+	for _, result := range w.results[:n] {
+		downloadMbps += result.DownloadMbps
+		uploadMbps += result.UploadMbps
+		pingMs += result.PingMs
+	}
+	return downloadMbps / float64(n), uploadMbps / float64(n), pingMs / float64(n)
+}
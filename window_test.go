@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"speedtest-cron/speedtest"
+)
+
+func windowResult(mbps float64) *speedtest.FormattedSpeedTest {
+	return &speedtest.FormattedSpeedTest{DownloadMbps: mbps, UploadMbps: mbps / 10, PingMs: mbps / 100}
+}
+
+func TestRollingWindow(t *testing.T) {
+	w := newRollingWindow(3)
+	if n := w.Len(); n != 0 {
+		t.Errorf("Len() of an empty window = %d, want 0", n)
+	}
+	if down, up, ping := w.Average(); down != 0 || up != 0 || ping != 0 {
+		t.Errorf("Average() of an empty window = %v, %v, %v, want 0, 0, 0", down, up, ping)
+	}
+
+	w.Add(windowResult(100))
+	w.Add(windowResult(200))
+	if n := w.Len(); n != 2 {
+		t.Errorf("Len() before the window fills = %d, want 2", n)
+	}
+	if down, _, _ := w.Average(); down != 150 {
+		t.Errorf("Average() download before the window fills = %v, want 150", down)
+	}
+
+	w.Add(windowResult(300))
+	if n := w.Len(); n != 3 {
+		t.Errorf("Len() of a full window = %d, want 3", n)
+	}
+
+	// Wraps around, overwriting 100 and then 200
+	w.Add(windowResult(400))
+	w.Add(windowResult(500))
+	if n := w.Len(); n != 3 {
+		t.Errorf("Len() after wrapping around = %d, want 3", n)
+	}
+	down, up, ping := w.Average()
+	if down != 400 || up != 40 || ping != 4 {
+		t.Errorf("Average() after wrapping around = %v, %v, %v, want 400, 40, 4", down, up, ping)
+	}
+}